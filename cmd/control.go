@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"autossh/internal/config"
+	"autossh/internal/control"
+)
+
+// runControlCommand 向主连接发送控制命令 (-O)
+func runControlCommand(cfg *config.Config, command string) error {
+	path := cfg.ControlPath()
+	if path == "" {
+		return fmt.Errorf("-O 需要通过 -S 或 control.path 指定控制套接字")
+	}
+	if strings.Contains(cfg.Control.Path, "%h") && cfg.Server.Host == "" {
+		return fmt.Errorf("控制套接字路径包含 %%h，需要指定主机")
+	}
+
+	req := control.Request{Command: command}
+	switch command {
+	case control.CmdCheck, control.CmdExit:
+	case control.CmdForward, control.CmdCancel:
+		if cfg.Tunnels.Count() == 0 {
+			return fmt.Errorf("-O %s 需要通过 -L/-R/-D 指定转发", command)
+		}
+		req.Tunnels = cfg.Tunnels
+	default:
+		return fmt.Errorf("未知的控制命令: %s (期望: check、forward、cancel 或 exit)", command)
+	}
+
+	resp, err := control.Send(path, req)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case control.CmdCheck:
+		fmt.Printf("主连接运行中 (pid=%d, server=%s, connected=%v)\n", resp.PID, resp.Server, resp.Connected)
+		for _, g := range resp.Groups {
+			fmt.Printf("  %-14s %s\n", g.Type, strings.Join(g.Tunnels, ", "))
+		}
+	case control.CmdForward:
+		fmt.Printf("已添加 %d 个转发\n", req.Tunnels.Count())
+	case control.CmdCancel:
+		fmt.Printf("已取消 %d 个转发\n", resp.Removed)
+	case control.CmdExit:
+		fmt.Println("已请求主连接退出")
+	}
+	return nil
+}
+
+// runAttached 将转发附加到已有的主连接，退出时自动取消
+func runAttached(cfg *config.Config, path string) error {
+	c, err := control.Dial(path)
+	if err != nil {
+		return fmt.Errorf("连接控制套接字失败: %w", err)
+	}
+	defer c.Close()
+
+	if cfg.Session.Command != "" || cfg.DNS.Bind != "" || cfg.PAC.Bind != "" {
+		slog.Warn("复用主连接时只添加转发，忽略 session、dns 和 pac 配置")
+	}
+
+	resp, err := c.Request(control.Request{Command: control.CmdAttach, Tunnels: cfg.Tunnels})
+	if err != nil {
+		return fmt.Errorf("复用主连接失败: %w", err)
+	}
+	slog.Info("已复用主连接", "path", path, "server", resp.Server, "pid", resp.PID, "tunnels", cfg.Tunnels.Count())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(done)
+	}()
+
+	select {
+	case sig := <-sigChan:
+		slog.Info("收到信号，正在退出...", "signal", sig)
+		return nil
+	case <-done:
+		return fmt.Errorf("主连接已退出")
+	}
+}
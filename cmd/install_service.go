@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/service"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	serviceFormat   string
+	serviceName     string
+	serviceUser     string
+	serviceEnvFile  string
+	serviceOutput   string
+	serviceWatchdog time.Duration
+	serviceSocket   bool
+	servicePrint    bool
+	serviceForce    bool
+)
+
+// installServiceCmd 根据当前配置生成服务文件
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service",
+	Short: "根据配置文件生成 systemd 服务单元 (或 OpenRC、launchd 服务文件)",
+	Long: `根据配置文件生成服务文件，包含重启策略、安全加固和保存密码的环境变量文件。
+
+systemd 格式写入 /etc/systemd/system，之后执行 systemctl daemon-reload 和 systemctl enable --now 即可启动；
+OpenRC 和 launchd 格式只生成文件 (默认写入当前目录)，需要手动复制到 /etc/init.d 或 /Library/LaunchDaemons。
+
+服务单元只允许写入运行状态文件、流量配额文件、远程命令输出文件、控制套接字和 Unix 套接字所在的目录，
+使用 1024 以下的端口时保留 CAP_NET_BIND_SERVICE。环境变量文件已存在时不会覆盖。`,
+	Example: `  # 生成并安装 systemd 服务
+  sudo autossh install-service -c /etc/autossh/config.yaml
+
+  # 同时生成套接字激活单元，由 systemd 监听本地转发和动态转发的地址
+  sudo autossh install-service -c /etc/autossh/config.yaml --socket
+
+  # 只输出到终端查看
+  autossh install-service -c /etc/autossh/config.yaml --print
+
+  # 生成 OpenRC 初始化脚本
+  autossh install-service -c /etc/autossh/config.yaml --format openrc -o ./dist`,
+	Args: cobra.NoArgs,
+	RunE: runInstallService,
+}
+
+func init() {
+	installServiceCmd.Flags().StringVarP(&cfgFile, "config", "c", "", "配置文件路径 (默认查找 ./config.yaml 和 ~/.autossh/config.yaml)")
+	installServiceCmd.Flags().StringVar(&serviceFormat, "format", service.FormatSystemd, "服务文件格式: systemd、openrc 或 launchd")
+	installServiceCmd.Flags().StringVar(&serviceName, "name", "autossh", "服务名称")
+	installServiceCmd.Flags().StringVar(&serviceUser, "user", "autossh", "运行服务的用户 (为空则以 root 运行)")
+	installServiceCmd.Flags().StringVar(&serviceEnvFile, "env-file", "", "保存密码的环境变量文件 (默认: /etc/autossh/<name>.env, none = 不使用)")
+	installServiceCmd.Flags().StringVarP(&serviceOutput, "output", "o", "", "输出目录 (默认: systemd 为 /etc/systemd/system，其他格式为当前目录)")
+	installServiceCmd.Flags().DurationVar(&serviceWatchdog, "watchdog", 90*time.Second, "systemd 看门狗超时 (0 = 不启用)")
+	installServiceCmd.Flags().BoolVar(&serviceSocket, "socket", false, "同时生成 systemd 套接字激活单元")
+	installServiceCmd.Flags().BoolVar(&servicePrint, "print", false, "输出到终端，不写入文件")
+	installServiceCmd.Flags().BoolVar(&serviceForce, "force", false, "覆盖已存在的服务文件")
+	rootCmd.AddCommand(installServiceCmd)
+}
+
+func runInstallService(cmd *cobra.Command, args []string) error {
+	if serviceSocket && serviceFormat != service.FormatSystemd {
+		return fmt.Errorf("--socket 只适用于 systemd 格式")
+	}
+
+	cfg, err := config.LoadFromFile(cfgFile)
+	if err != nil {
+		return err
+	}
+	// 服务使用配置文件的绝对路径启动，不依赖工作目录
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return fmt.Errorf("未找到配置文件，请通过 -c 指定")
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("配置文件无效: %w", err)
+	}
+	if cfg.Auth.Password != "" || cfg.Auth.Passphrase != "" {
+		slog.Warn("配置文件中包含明文密码，建议移到环境变量文件中", "env", []string{config.EnvPassword, config.EnvPassphrase})
+	}
+	if cfg.Bandwidth.QuotaEnabled() && cfg.Bandwidth.QuotaFile == "" {
+		slog.Warn("流量配额默认保存在用户主目录中，服务可能无法写入，建议将 bandwidth.quota_file 设置到状态目录", "dir", filepath.Join("/var/lib", serviceName))
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("获取可执行文件路径失败: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+
+	envFile := serviceEnvFile
+	switch envFile {
+	case "":
+		envFile = filepath.Join("/etc/autossh", serviceName+".env")
+	case "none":
+		envFile = ""
+	}
+	if envFile != "" && !filepath.IsAbs(envFile) {
+		return fmt.Errorf("环境变量文件需要使用绝对路径: %s", envFile)
+	}
+
+	files, err := service.Render(serviceFormat, cfg, service.Options{
+		Name:       serviceName,
+		Binary:     binary,
+		ConfigFile: path,
+		User:       serviceUser,
+		EnvFile:    envFile,
+		Watchdog:   serviceWatchdog,
+		Socket:     serviceSocket,
+	})
+	if err != nil {
+		return err
+	}
+
+	if servicePrint {
+		for _, f := range files {
+			fmt.Printf("==> %s <==\n%s\n", f.Name, f.Content)
+		}
+		return nil
+	}
+
+	output := serviceOutput
+	if output == "" && serviceFormat == service.FormatSystemd {
+		output = "/etc/systemd/system"
+	}
+	for _, f := range files {
+		dst := filepath.Join(output, f.Name)
+		// systemd 直接读取安装位置的环境变量文件，其他格式只生成文件
+		if f.Secret && serviceFormat == service.FormatSystemd {
+			dst = envFile
+		}
+		written, err := writeServiceFile(dst, f)
+		if err != nil {
+			return err
+		}
+		if written {
+			fmt.Printf("已生成 %s\n", dst)
+		} else {
+			fmt.Printf("%s 已存在，未覆盖\n", dst)
+		}
+	}
+
+	if serviceFormat == service.FormatSystemd {
+		unit := serviceName + ".service"
+		if serviceSocket {
+			unit += " " + serviceName + ".socket"
+		}
+		fmt.Printf("\n启动服务:\n  systemctl daemon-reload\n  systemctl enable --now %s\n", unit)
+		if serviceUser != "" {
+			fmt.Printf("\n服务以用户 %s 运行，需要确保该用户存在并可以读取配置文件和私钥:\n  useradd --system --no-create-home --shell /usr/sbin/nologin %s\n", serviceUser, serviceUser)
+		}
+	}
+	return nil
+}
+
+// writeServiceFile 写入服务文件，返回是否写入
+// 环境变量文件已存在时保留 (其中保存了密码)，其他文件需要 --force 才覆盖
+func writeServiceFile(path string, f service.File) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		if f.Secret {
+			return false, nil
+		}
+		if !serviceForce {
+			return false, fmt.Errorf("%s 已存在 (使用 --force 覆盖)", path)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("创建目录失败: %w", err)
+	}
+	if err := os.WriteFile(path, f.Content, f.Mode); err != nil {
+		return false, fmt.Errorf("写入 %s 失败: %w", path, err)
+	}
+	// WriteFile 只在创建文件时设置权限
+	if err := os.Chmod(path, f.Mode); err != nil {
+		return false, err
+	}
+	return true, nil
+}
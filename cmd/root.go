@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -8,8 +9,12 @@ import (
 	"syscall"
 
 	"autossh/internal/config"
+	"autossh/internal/control"
+	"autossh/internal/dnsproxy"
 	"autossh/internal/monitor"
+	"autossh/internal/pac"
 	"autossh/internal/ssh"
+	"autossh/internal/systemd"
 	"autossh/internal/tunnel"
 
 	"github.com/spf13/cobra"
@@ -24,6 +29,14 @@ var (
 	sshPort       int
 	identityFile  string
 	verbose       bool
+	pacBind       string
+	stateFile     string
+	stdioForward  string
+	controlPath   string
+	controlMaster string
+	controlCmd    string
+	connections   int
+	remoteAllow   []string
 )
 
 // rootCmd 根命令
@@ -34,6 +47,9 @@ var rootCmd = &cobra.Command{
 - 本地端口转发 (-L)
 - 远程端口转发 (-R)  
 - 动态端口转发/SOCKS5代理 (-D)
+- 反向动态端口转发/远程SOCKS5代理 (-R port)
+- 标准输入输出转发，可作为 ProxyCommand (-W)
+- 通过控制套接字复用连接 (-S, -O)
 - 自动检测断线并重连
 - 密码和密钥认证`,
 	Example: `  # 本地端口转发
@@ -42,9 +58,29 @@ var rootCmd = &cobra.Command{
   # 远程端口转发
   autossh -R 9090:localhost:22 user@host
 
+  # 远程端口由服务器分配 (实际端口见日志和 --state-file)
+  autossh -R 0:localhost:22 --state-file /run/autossh/state.json user@host
+
+  # 端口范围转发 (展开为多个隧道)
+  autossh -L 9000-9010:localhost:9000-9010 user@host
+
+  # IPv6 地址使用方括号
+  autossh -L [::1]:8080:[fe80::1]:80 user@[2001:db8::1]:2222
+
   # SOCKS5 代理
   autossh -D 1080 user@host
 
+  # 远程 SOCKS5 代理 (服务器经由本机出口，只允许访问 192.168.1.0/24)
+  autossh -R 1080 --remote-allow 192.168.1.0/24 user@host
+
+  # 作为其他 SSH 客户端的 ProxyCommand
+  ssh -o ProxyCommand='autossh -W %h:%p user@jumphost' user@internal
+
+  # 主连接监听控制套接字，之后的 autossh 复用同一连接
+  autossh -S ~/.autossh/ctl-%r@%h:%p --control-master auto -D 1080 user@host
+  autossh -S ~/.autossh/ctl-%r@%h:%p -O forward -L 8080:localhost:80 user@host
+  autossh -S ~/.autossh/ctl-%r@%h:%p -O check user@host
+
   # 使用配置文件
   autossh -c config.yaml
 
@@ -58,11 +94,19 @@ func init() {
 	rootCmd.Flags().StringVarP(&cfgFile, "config", "c", "", "配置文件路径")
 	rootCmd.Flags().IntVarP(&monitorPort, "monitor", "M", 0, "监控端口 (0 = 禁用, 使用 ServerAliveInterval)")
 	rootCmd.Flags().StringArrayVarP(&localForwards, "local", "L", nil, "本地端口转发 [bind_address:]port:host:hostport")
-	rootCmd.Flags().StringArrayVarP(&remoteForwards, "remote", "R", nil, "远程端口转发 [bind_address:]port:host:hostport")
-	rootCmd.Flags().StringArrayVarP(&dynamicForwards, "dynamic", "D", nil, "动态端口转发 (SOCKS5) [bind_address:]port")
+	rootCmd.Flags().StringArrayVarP(&remoteForwards, "remote", "R", nil, "远程端口转发 [bind_address:]port:host:hostport, 仅指定 [bind_address:]port 时为远程SOCKS5代理")
+	rootCmd.Flags().StringArrayVarP(&dynamicForwards, "dynamic", "D", nil, "动态端口转发 (SOCKS5/SOCKS4/HTTP代理) [bind_address:]port")
 	rootCmd.Flags().IntVarP(&sshPort, "port", "p", 22, "SSH端口")
 	rootCmd.Flags().StringVarP(&identityFile, "identity", "i", "", "私钥文件路径")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "详细输出")
+	rootCmd.Flags().StringVar(&pacBind, "pac", "", "PAC 文件服务监听地址 (例如: 127.0.0.1:8090)")
+	rootCmd.Flags().StringVar(&stateFile, "state-file", "", "运行状态文件 (JSON, 记录远程转发实际监听的端口)")
+	rootCmd.Flags().StringVarP(&stdioForward, "stdio", "W", "", "将标准输入输出转发到 host:port (用作 ProxyCommand)")
+	rootCmd.Flags().StringVarP(&controlPath, "control-path", "S", "", "控制套接字路径 (支持 %h %p %r)")
+	rootCmd.Flags().StringVar(&controlMaster, "control-master", "", "主连接模式: yes、no 或 auto")
+	rootCmd.Flags().StringVarP(&controlCmd, "control", "O", "", "向主连接发送控制命令: check、forward、cancel 或 exit")
+	rootCmd.Flags().IntVar(&connections, "connections", 0, "到服务器的SSH连接数 (大于 1 时分散本地转发的通道)")
+	rootCmd.Flags().StringArrayVar(&remoteAllow, "remote-allow", nil, "远程SOCKS5代理 (-R port) 允许访问的本地目标 (IP、CIDR、主机名或 *)")
 }
 
 // Execute 执行根命令
@@ -78,7 +122,12 @@ func run(cmd *cobra.Command, args []string) error {
 	if verbose {
 		logLevel = slog.LevelDebug
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	// -W 模式下 stdout 用于转发数据，日志输出到 stderr
+	logOutput := os.Stdout
+	if stdioForward != "" {
+		logOutput = os.Stderr
+	}
+	logger := slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: logLevel}))
 	slog.SetDefault(logger)
 
 	// 加载配置
@@ -87,17 +136,35 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// 控制命令只需要控制套接字路径和转发参数
+	if controlCmd != "" {
+		return runControlCommand(cfg, controlCmd)
+	}
+
 	// 验证配置
 	if err := cfg.Validate(); err != nil {
 		return fmt.Errorf("配置验证失败: %w", err)
 	}
 
+	if cfg.Stdio != "" {
+		return runStdio(cfg)
+	}
+
+	// 已有主连接时复用
+	socketPath := cfg.ControlPath()
+	if socketPath != "" && cfg.Control.Master != config.ControlMasterYes && control.Alive(socketPath) {
+		return runAttached(cfg, socketPath)
+	}
+
 	slog.Info("启动 autossh",
 		"server", cfg.Address(),
 		"user", cfg.Server.User,
 		"auth", cfg.Auth.Type,
 	)
 
+	// 使用 systemd 套接字激活传递的监听套接字
+	tunnel.Inherit(systemd.ListenFiles())
+
 	// 创建SSH客户端
 	client := ssh.NewClient(cfg)
 
@@ -106,6 +173,42 @@ func run(cmd *cobra.Command, args []string) error {
 
 	// 创建监控器
 	mon := monitor.NewMonitor(client, tunnelMgr, cfg)
+	if systemd.Enabled() {
+		mon.SetStatusFunc(notifyStatus())
+	}
+	if timeout := systemd.WatchdogInterval(); timeout > 0 {
+		slog.Debug("已启用 systemd 看门狗", "timeout", timeout)
+		mon.SetWatchdog(timeout, notifyWatchdog)
+	}
+
+	// 启动 PAC 文件服务
+	if cfg.PAC.Bind != "" {
+		pacServer := pac.NewServer(cfg)
+		if err := pacServer.Start(); err != nil {
+			return err
+		}
+		defer pacServer.Stop()
+	}
+
+	// 启动 DNS 转发
+	if cfg.DNS.Bind != "" {
+		dnsServer := dnsproxy.NewServer(client, cfg.DNS)
+		if err := dnsServer.Start(); err != nil {
+			return err
+		}
+		defer dnsServer.Stop()
+	}
+
+	// 作为主连接监听控制套接字
+	var controlExit <-chan struct{}
+	if cfg.Control.Master == config.ControlMasterYes || cfg.Control.Master == config.ControlMasterAuto {
+		ctl := control.NewServer(socketPath, client, tunnelMgr)
+		if err := ctl.Listen(); err != nil {
+			return err
+		}
+		defer ctl.Stop()
+		controlExit = ctl.Exit()
+	}
 
 	// 设置信号处理
 	sigChan := make(chan os.Signal, 1)
@@ -117,18 +220,42 @@ func run(cmd *cobra.Command, args []string) error {
 		errChan <- mon.Start()
 	}()
 
+	// systemd 服务: SIGHUP 重新加载隧道 (ExecReload)
+	// 终端中运行时保留 SIGHUP 的默认行为，关闭终端时退出
+	done := make(chan struct{})
+	defer close(done)
+	if systemd.Enabled() {
+		go handleReload(tunnelMgr, done)
+	}
+
 	// 等待退出信号或错误
 	select {
 	case sig := <-sigChan:
 		slog.Info("收到信号，正在退出...", "signal", sig)
-		mon.Stop()
+	case <-controlExit:
+		slog.Info("收到控制命令，正在退出...")
 	case err := <-errChan:
-		if err != nil {
-			return err
-		}
+		return err
 	}
 
-	return nil
+	// 等待现有连接结束后退出，再次收到信号时不再等待，立即关闭所有连接
+	// 仍然正常返回，执行删除控制套接字、保存流量配额等清理
+	systemd.Notify(systemd.Stopping)
+	if cfg.Shutdown.GracePeriod > 0 {
+		slog.Info("再次发送信号可立即退出")
+	}
+	drain, abort := context.WithCancel(context.Background())
+	defer abort()
+	go func() {
+		select {
+		case sig := <-sigChan:
+			slog.Warn("再次收到信号，立即关闭所有连接", "signal", sig)
+			abort()
+		case <-drain.Done():
+		}
+	}()
+	mon.Shutdown(drain)
+	return <-errChan
 }
 
 // loadConfig 从命令行参数和配置文件加载配置
@@ -146,6 +273,9 @@ func loadConfig(args []string) (*config.Config, error) {
 		cfg = config.DefaultConfig()
 	}
 
+	// 环境变量中的密码覆盖配置文件
+	cfg.Auth.LoadEnv()
+
 	// 命令行参数覆盖配置文件
 	if len(args) > 0 {
 		user, host, port, err := config.ParseTarget(args[0])
@@ -174,20 +304,31 @@ func loadConfig(args []string) (*config.Config, error) {
 
 	// 解析本地转发
 	for _, spec := range localForwards {
-		tunnel, err := config.ParseLocalForward(spec)
+		tunnels, err := config.ParseLocalForward(spec)
 		if err != nil {
 			return nil, err
 		}
-		cfg.Tunnels.Local = append(cfg.Tunnels.Local, *tunnel)
+		cfg.Tunnels.Local = append(cfg.Tunnels.Local, tunnels...)
 	}
 
 	// 解析远程转发
 	for _, spec := range remoteForwards {
-		tunnel, err := config.ParseRemoteForward(spec)
+		// 只有端口时为反向动态转发 (远程 SOCKS5 代理)
+		if config.IsRemoteDynamicSpec(spec) {
+			tunnel, err := config.ParseRemoteDynamicForward(spec)
+			if err != nil {
+				return nil, err
+			}
+			tunnel.Destinations.Allow = remoteAllow
+			cfg.Tunnels.RemoteDynamic = append(cfg.Tunnels.RemoteDynamic, *tunnel)
+			continue
+		}
+
+		tunnels, err := config.ParseRemoteForward(spec)
 		if err != nil {
 			return nil, err
 		}
-		cfg.Tunnels.Remote = append(cfg.Tunnels.Remote, *tunnel)
+		cfg.Tunnels.Remote = append(cfg.Tunnels.Remote, tunnels...)
 	}
 
 	// 解析动态转发
@@ -199,6 +340,37 @@ func loadConfig(args []string) (*config.Config, error) {
 		cfg.Tunnels.Dynamic = append(cfg.Tunnels.Dynamic, *tunnel)
 	}
 
+	if pacBind != "" {
+		cfg.PAC.Bind = pacBind
+	}
+	if stateFile != "" {
+		cfg.StateFile = stateFile
+	}
+	if controlPath != "" {
+		cfg.Control.Path = controlPath
+	}
+	if controlMaster != "" {
+		cfg.Control.Master = controlMaster
+	}
+	if connections > 0 {
+		cfg.Connections = connections
+	}
+
+	// 标准输入输出转发，与 OpenSSH 一致不建立端口转发
+	if stdioForward != "" {
+		target, err := config.ParseStdioForward(stdioForward)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Stdio = target
+		if cfg.Tunnels.Count() > 0 {
+			slog.Warn("-W 模式下忽略端口转发配置")
+			cfg.Tunnels = config.TunnelsConfig{}
+		}
+		// 只有一个通道，不需要连接池
+		cfg.Connections = 1
+	}
+
 	// 如果没有指定用户名，使用当前系统用户
 	if cfg.Server.User == "" {
 		cfg.Server.User = os.Getenv("USER")
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/monitor"
+	"autossh/internal/ssh"
+	"autossh/internal/tunnel"
+)
+
+// runStdio 标准输入输出转发模式 (-W)
+// 连接失败时按重连配置重试，转发开始后任意一端关闭即退出
+func runStdio(cfg *config.Config) error {
+	// stdin/stdout 用于转发数据，无法交互输入密码
+	if cfg.Auth.Type == "password" && cfg.Auth.Password == "" {
+		return fmt.Errorf("-W 模式下无法交互输入密码，请使用密钥认证或在配置文件中设置密码")
+	}
+
+	// stdout 被关闭时返回 EPIPE 而不是被信号终止
+	signal.Ignore(syscall.SIGPIPE)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client := ssh.NewClient(cfg)
+	mon := monitor.NewMonitor(client, nil, cfg)
+	go func() {
+		<-ctx.Done()
+		mon.Stop()
+	}()
+
+	if err := mon.Connect(); err != nil {
+		if errors.Is(err, monitor.ErrStopped) {
+			return nil
+		}
+		return err
+	}
+	defer client.Close()
+
+	// 连接断开时结束转发
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	errChan := make(chan error, 1)
+	client.StartKeepAlive(30*time.Second, errChan, nil)
+	go func() {
+		select {
+		case err := <-errChan:
+			cancel(fmt.Errorf("连接断开: %w", err))
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := tunnel.NewStdioForward(client, cfg.Stdio).Run(ctx); err != nil {
+		return err
+	}
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+	return nil
+}
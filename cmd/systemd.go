@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"autossh/internal/monitor"
+	"autossh/internal/systemd"
+	"autossh/internal/tunnel"
+)
+
+// notifyStatus 返回将监控器状态通知 systemd 的回调，连接和隧道首次就绪时发送 READY=1
+func notifyStatus() monitor.StatusFunc {
+	var ready sync.Once
+	return func(status string, ok bool) {
+		state := []string{systemd.Status("%s", status)}
+		if ok {
+			ready.Do(func() { state = append(state, systemd.Ready) })
+		}
+		if err := systemd.Notify(state...); err != nil {
+			slog.Debug("systemd 通知失败", "error", err)
+		}
+	}
+}
+
+// notifyWatchdog 通知 systemd 看门狗，由监控器在保活成功和重连期间调用
+func notifyWatchdog() {
+	if err := systemd.Notify(systemd.Watchdog); err != nil {
+		slog.Debug("systemd 通知失败", "error", err)
+	}
+}
+
+// handleReload 收到 SIGHUP 时重启隧道，重新读取认证用户文件、路由规则和证书
+func handleReload(mgr *tunnel.Manager, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+		}
+
+		slog.Info("收到 SIGHUP，重新加载隧道")
+		systemd.Notify(systemd.Reloading, systemd.Status("正在重新加载"))
+		if err := mgr.Restart(); err != nil {
+			slog.Error("重新加载隧道失败", "error", err)
+			systemd.Notify(systemd.Ready, systemd.Status("重新加载失败: %v", err))
+			continue
+		}
+		systemd.Notify(systemd.Ready, systemd.Status("已重新加载，%d 个隧道", mgr.TunnelCount()))
+	}
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"os"
+
+	"autossh/internal/udprelay"
+
+	"github.com/spf13/cobra"
+)
+
+// udpRelayCmd UDP中继辅助命令
+// 由 SOCKS5 UDP ASSOCIATE 通过 SSH exec 通道在远程服务器上启动
+var udpRelayCmd = &cobra.Command{
+	Use:    "udp-relay",
+	Short:  "UDP中继辅助进程 (在远程服务器上运行)",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return udprelay.Serve(os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(udpRelayCmd)
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,7 +20,18 @@ type Config struct {
 	Auth      AuthConfig      `mapstructure:"auth"`
 	Tunnels   TunnelsConfig   `mapstructure:"tunnels"`
 	Reconnect ReconnectConfig `mapstructure:"reconnect"`
+	PAC       PACConfig       `mapstructure:"pac"`
+	DNS       DNSConfig       `mapstructure:"dns"`
+	Bandwidth BandwidthConfig `mapstructure:"bandwidth"`
+	Relay     RelayConfig     `mapstructure:"relay"`
+	Shutdown  ShutdownConfig  `mapstructure:"shutdown"`
+	Session   SessionConfig   `mapstructure:"session"`
+	Control   ControlConfig   `mapstructure:"control"`
+	StateFile string          `mapstructure:"state_file"` // 运行状态文件 (JSON, 记录远程转发实际监听的地址)
+	Stdio     string          `mapstructure:"-"`          // 标准输入输出转发目标 (-W，仅命令行)
 	LogLevel  string          `mapstructure:"log_level"`
+
+	Connections int `mapstructure:"connections"` // 到服务器的SSH连接数，大于 1 时本地发起的通道分配到负载最低的连接
 }
 
 // ServerConfig SSH服务器配置
@@ -34,28 +49,549 @@ type AuthConfig struct {
 	Passphrase string `mapstructure:"passphrase"` // 密钥密码短语
 }
 
+// 覆盖配置文件中密码的环境变量，作为服务运行时可将密码放在只有 root 可读的环境变量文件中
+const (
+	EnvPassword   = "AUTOSSH_PASSWORD"
+	EnvPassphrase = "AUTOSSH_PASSPHRASE"
+)
+
+// LoadEnv 使用环境变量中的密码和密钥密码短语覆盖配置
+func (a *AuthConfig) LoadEnv() {
+	if v, ok := os.LookupEnv(EnvPassword); ok {
+		a.Password = v
+	}
+	if v, ok := os.LookupEnv(EnvPassphrase); ok {
+		a.Passphrase = v
+	}
+}
+
 // TunnelsConfig 隧道配置
 type TunnelsConfig struct {
-	Local   []LocalTunnel   `mapstructure:"local"`
-	Remote  []RemoteTunnel  `mapstructure:"remote"`
-	Dynamic []DynamicTunnel `mapstructure:"dynamic"`
+	Local         []LocalTunnel         `mapstructure:"local"`
+	Remote        []RemoteTunnel        `mapstructure:"remote"`
+	Dynamic       []DynamicTunnel       `mapstructure:"dynamic"`
+	RemoteDynamic []RemoteDynamicTunnel `mapstructure:"remote_dynamic"`
 }
 
 // LocalTunnel 本地端口转发配置 (-L)
+// Bind 和 Target 均可为 Unix 套接字路径 (包含 "/")
 type LocalTunnel struct {
-	Bind   string `mapstructure:"bind"`   // 本地监听地址 (例如: 127.0.0.1:8080)
-	Target string `mapstructure:"target"` // 远程目标地址 (例如: localhost:80)
+	Name        string `mapstructure:"name"`         // 隧道名称 (端口范围展开的隧道共用此名称)
+	Bind        string `mapstructure:"bind"`         // 本地监听地址 (例如: 127.0.0.1:8080 或 /tmp/docker.sock)
+	Target      string `mapstructure:"target"`       // 远程目标地址 (例如: localhost:80 或 /var/run/docker.sock)
+	SocketMode  string `mapstructure:"socket_mode"`  // 本地套接字文件权限 (八进制, 例如: "0660")
+	SocketOwner string `mapstructure:"socket_owner"` // 本地套接字文件属主 (user[:group])
+	Sources     ACL    `mapstructure:"sources"`      // 来源地址访问控制 (CIDR 或 IP)
+	ConnLimits  `mapstructure:",squash"`
+
+	ProxyProtocol       string `mapstructure:"proxy_protocol"`        // 向目标发送 PROXY 协议头: v1 或 v2 (为空则不发送)
+	AcceptProxyProtocol bool   `mapstructure:"accept_proxy_protocol"` // 要求客户端发送 PROXY 协议头并剥离
+
+	TLS ListenerTLSConfig `mapstructure:"tls"` // 本地监听的 TLS 终止
 }
 
 // RemoteTunnel 远程端口转发配置 (-R)
+// Bind 和 Target 均可为 Unix 套接字路径 (包含 "/")
 type RemoteTunnel struct {
-	Bind   string `mapstructure:"bind"`   // 远程监听地址 (例如: 0.0.0.0:9090)
-	Target string `mapstructure:"target"` // 本地目标地址 (例如: localhost:22)
+	Name        string `mapstructure:"name"`         // 隧道名称 (端口范围展开的隧道共用此名称)
+	Bind        string `mapstructure:"bind"`         // 远程监听地址 (例如: 0.0.0.0:9090 或 /tmp/pg.sock)，端口为 0 时由服务器分配
+	Target      string `mapstructure:"target"`       // 本地目标地址 (例如: localhost:22 或 /var/run/postgresql/.s.PGSQL.5432)
+	UnlinkStale bool   `mapstructure:"unlink_stale"` // 监听前删除远程残留的套接字文件
+	ConnLimits  `mapstructure:",squash"`
+
+	ProxyProtocol string `mapstructure:"proxy_protocol"` // 向本地目标发送 PROXY 协议头: v1 或 v2 (为空则不发送)
+	Hook          string `mapstructure:"hook"`           // 远程监听建立后执行的命令 (sh -c)，通过环境变量获取实际端口
 }
 
 // DynamicTunnel 动态端口转发配置 (-D)
 type DynamicTunnel struct {
-	Bind string `mapstructure:"bind"` // 本地SOCKS5监听地址 (例如: 127.0.0.1:1080)
+	Bind         string          `mapstructure:"bind"`         // 本地SOCKS5监听地址 (例如: 127.0.0.1:1080)
+	Auth         SocksAuthConfig `mapstructure:"auth"`         // SOCKS5 用户名/密码认证 (为空则不认证)
+	UDP          SocksUDPConfig  `mapstructure:"udp"`          // SOCKS5 UDP ASSOCIATE
+	Routing      RoutingConfig   `mapstructure:"routing"`      // 按规则选择连接方式
+	Sources      ACL             `mapstructure:"sources"`      // 来源地址访问控制 (CIDR 或 IP)
+	Destinations ACL             `mapstructure:"destinations"` // 目标地址访问控制
+	ConnLimits   `mapstructure:",squash"`
+
+	AcceptProxyProtocol bool `mapstructure:"accept_proxy_protocol"` // 要求客户端发送 PROXY 协议头并剥离
+
+	TLS ListenerTLSConfig `mapstructure:"tls"` // 本地监听的 TLS 终止
+}
+
+// ListenerTLSConfig 本地监听的 TLS 配置
+// 证书、私钥和 CA 文件修改后自动重新加载
+type ListenerTLSConfig struct {
+	Cert     string `mapstructure:"cert"`      // 证书文件 (PEM, 可包含证书链)
+	Key      string `mapstructure:"key"`       // 私钥文件 (PEM)
+	ClientCA string `mapstructure:"client_ca"` // 校验客户端证书的 CA 文件 (PEM)，为空则不要求客户端证书
+}
+
+// Enabled 是否启用 TLS
+func (t *ListenerTLSConfig) Enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+// validate 检查 TLS 配置
+func (t *ListenerTLSConfig) validate(bind string) error {
+	if t.ClientCA != "" && !t.Enabled() {
+		return fmt.Errorf("client_ca 需要同时配置 cert 和 key: %s", bind)
+	}
+	if t.Enabled() && (t.Cert == "" || t.Key == "") {
+		return fmt.Errorf("TLS 需要同时配置 cert 和 key: %s", bind)
+	}
+	return nil
+}
+
+// expandPaths 展开文件路径中的 ~
+func (t *ListenerTLSConfig) expandPaths() {
+	for _, p := range []*string{&t.Cert, &t.Key, &t.ClientCA} {
+		if *p != "" {
+			*p = expandPath(*p)
+		}
+	}
+}
+
+// PROXY 协议版本
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// validateProxyProtocol 检查 PROXY 协议版本
+func validateProxyProtocol(version string) error {
+	switch version {
+	case "", ProxyProtocolV1, ProxyProtocolV2:
+		return nil
+	}
+	return fmt.Errorf("无效的 PROXY 协议版本: %s (期望: v1 或 v2)", version)
+}
+
+// ConnLimits 单个隧道的连接限制，零值表示不限制
+type ConnLimits struct {
+	MaxConnections          int           `mapstructure:"max_connections"`            // 最大并发连接数
+	MaxConnectionsPerSource int           `mapstructure:"max_connections_per_source"` // 每个来源 IP 的最大并发连接数
+	IdleTimeout             time.Duration `mapstructure:"idle_timeout"`               // 双向均无数据时关闭连接
+	MaxLifetime             time.Duration `mapstructure:"max_lifetime"`               // 连接最长存活时间
+	UploadRate              string        `mapstructure:"upload_rate"`                // 上传速率 (发往SSH服务器方向, 例如: 1MB 表示每秒 1MB)
+	DownloadRate            string        `mapstructure:"download_rate"`              // 下载速率 (来自SSH服务器方向)
+}
+
+// validate 检查连接限制
+func (l ConnLimits) validate(bind string) error {
+	if l.MaxConnections < 0 || l.MaxConnectionsPerSource < 0 || l.IdleTimeout < 0 || l.MaxLifetime < 0 {
+		return fmt.Errorf("连接限制不能为负数: %s", bind)
+	}
+	for _, rate := range []string{l.UploadRate, l.DownloadRate} {
+		if _, err := ParseByteSize(rate); err != nil {
+			return fmt.Errorf("无效的速率限制 %s: %w", bind, err)
+		}
+	}
+	return nil
+}
+
+// ACL 访问控制列表
+// 先检查 Deny，匹配则拒绝；Allow 非空时必须匹配其中一项
+type ACL struct {
+	Allow   []string `mapstructure:"allow"`
+	Deny    []string `mapstructure:"deny"`
+	Resolve string   `mapstructure:"resolve"` // 动态转发目标域名的解析位置: remote (默认, 只由 SSH 服务器解析) 或 local (先在本地解析并检查 IP 和 CIDR 条目)
+}
+
+// Enabled 是否配置了访问控制
+func (a ACL) Enabled() bool {
+	return len(a.Allow) > 0 || len(a.Deny) > 0
+}
+
+// ACLEntry 解析后的目标访问控制条目
+// 格式: 目标[:端口或端口范围]，目标可为 *、IP、CIDR、主机名或 *.域名 (匹配所有子域名)，
+// IPv6 地址带端口时需使用方括号，例如 [fd00::/8]:443
+type ACLEntry struct {
+	Any      bool         // 目标为 *
+	Prefix   netip.Prefix // 目标为 IP 或 CIDR 时有效
+	Host     string       // 目标为主机名时有效 (小写)
+	PortLow  int
+	PortHigh int
+}
+
+// ParseACLEntry 解析目标访问控制条目
+func ParseACLEntry(s string) (ACLEntry, error) {
+	entry := ACLEntry{PortHigh: 65535}
+
+	target, ports := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return entry, fmt.Errorf("无效的访问控制条目: %s", s)
+		}
+		target = s[1:end]
+		rest := s[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return entry, fmt.Errorf("无效的访问控制条目: %s", s)
+			}
+			ports = rest[1:]
+		}
+	} else if _, err := ParsePrefix(s); err != nil {
+		// 非 IPv6 地址时，最后一个冒号后为端口
+		if idx := strings.LastIndex(s, ":"); idx != -1 {
+			target, ports = s[:idx], s[idx+1:]
+		}
+	}
+
+	if ports != "" {
+		low, high, err := ParsePortRange(ports)
+		if err != nil {
+			return entry, fmt.Errorf("无效的访问控制条目 %s: %w", s, err)
+		}
+		entry.PortLow, entry.PortHigh = low, high
+	}
+
+	switch {
+	case target == "*" || target == "":
+		entry.Any = true
+	default:
+		if prefix, err := ParsePrefix(target); err == nil {
+			entry.Prefix = prefix.Masked()
+		} else if strings.Contains(target, "/") {
+			return entry, fmt.Errorf("无效的访问控制条目 %s: %w", s, err)
+		} else {
+			entry.Host = strings.ToLower(strings.TrimSuffix(target, "."))
+		}
+	}
+
+	return entry, nil
+}
+
+// 路由动作
+const (
+	RouteTunnel   = "tunnel"   // 通过SSH隧道连接
+	RouteDirect   = "direct"   // 在本地直接连接
+	RouteReject   = "reject"   // 拒绝连接
+	RouteUpstream = "upstream" // 通过指定的上游代理连接
+)
+
+// RoutingConfig 动态转发的路由配置
+// 规则按顺序匹配，第一条匹配的规则生效
+type RoutingConfig struct {
+	Rules     []RouteRule `mapstructure:"rules"`      // 内联规则
+	RulesFile string      `mapstructure:"rules_file"` // 规则文件 (YAML, 修改后自动重新加载)，其规则排在内联规则之后
+	Default   string      `mapstructure:"default"`    // 无规则匹配时的动作 (默认: tunnel)
+	Upstreams []Upstream  `mapstructure:"upstreams"`  // 可选的上游代理
+}
+
+// RouteRule 路由规则
+// 同一类条件中任意一项匹配即可，已指定的各类条件需同时满足
+type RouteRule struct {
+	Domains  []string `mapstructure:"domains"`  // 域名后缀 (example.com 匹配自身及子域名)
+	Globs    []string `mapstructure:"globs"`    // 域名通配符 (例如: *.corp.*)
+	CIDRs    []string `mapstructure:"cidrs"`    // IP 网段 (只匹配 IP 形式的目标地址)
+	Ports    []string `mapstructure:"ports"`    // 端口或端口范围 (例如: 443, 8000-9000)
+	Action   string   `mapstructure:"action"`   // tunnel, direct, reject 或 upstream
+	Upstream string   `mapstructure:"upstream"` // action 为 upstream 时使用的上游代理名称
+}
+
+// Upstream 上游代理
+type Upstream struct {
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"` // socks5://[user:pass@]host:port 或 http://[user:pass@]host:port
+}
+
+// Enabled 是否配置了路由
+func (r RoutingConfig) Enabled() bool {
+	return len(r.Rules) > 0 || r.RulesFile != "" || (r.Default != "" && r.Default != RouteTunnel)
+}
+
+// Validate 验证路由规则
+func (r RoutingConfig) Validate(rules []RouteRule) error {
+	upstreams := make(map[string]bool)
+	for _, u := range r.Upstreams {
+		parsed, err := url.Parse(u.URL)
+		if err != nil || u.Name == "" || parsed.Host == "" || (parsed.Scheme != "socks5" && parsed.Scheme != "http") {
+			return fmt.Errorf("无效的上游代理: %s %s (期望: socks5:// 或 http://)", u.Name, u.URL)
+		}
+		if parsed.Scheme == "socks5" && parsed.User != nil {
+			pass, _ := parsed.User.Password()
+			if name := parsed.User.Username(); name == "" || len(name) > 255 || len(pass) > 255 {
+				return fmt.Errorf("无效的上游代理: %s (SOCKS5 用户名不能为空, 用户名和密码长度不能超过255)", u.Name)
+			}
+		}
+		upstreams[u.Name] = true
+	}
+
+	switch r.Default {
+	case "", RouteTunnel, RouteDirect, RouteReject:
+	default:
+		return fmt.Errorf("无效的默认路由动作: %s", r.Default)
+	}
+
+	for i, rule := range rules {
+		switch rule.Action {
+		case RouteTunnel, RouteDirect, RouteReject:
+		case RouteUpstream:
+			if !upstreams[rule.Upstream] {
+				return fmt.Errorf("路由规则 %d: 未定义的上游代理: %s", i+1, rule.Upstream)
+			}
+		default:
+			return fmt.Errorf("路由规则 %d: 无效的动作: %s", i+1, rule.Action)
+		}
+		for _, cidr := range rule.CIDRs {
+			if _, err := ParsePrefix(cidr); err != nil {
+				return fmt.Errorf("路由规则 %d: 无效的网段 %s: %w", i+1, cidr, err)
+			}
+		}
+		for _, ports := range rule.Ports {
+			if _, _, err := ParsePortRange(ports); err != nil {
+				return fmt.Errorf("路由规则 %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadRouteRules 从规则文件加载路由规则
+// 文件格式为 YAML，顶层 rules 列表与 routing.rules 相同
+func LoadRouteRules(path string) ([]RouteRule, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("读取路由规则文件失败: %w", err)
+	}
+
+	var rules []RouteRule
+	if err := v.UnmarshalKey("rules", &rules); err != nil {
+		return nil, fmt.Errorf("解析路由规则文件失败: %w", err)
+	}
+	return rules, nil
+}
+
+// SocksUDPConfig SOCKS5 UDP ASSOCIATE 配置
+// 数据报通过 SSH exec 通道在远程辅助进程 (autossh udp-relay) 中继
+type SocksUDPConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	HelperCommand string        `mapstructure:"helper_command"` // 远程辅助进程命令 (默认: autossh udp-relay)
+	IdleTimeout   time.Duration `mapstructure:"idle_timeout"`   // 关联空闲超时 (默认: 60s)
+}
+
+// SocksAuthConfig SOCKS5 用户名/密码认证配置 (RFC 1929)
+// 密码可为明文或 bcrypt 哈希 ($2a$/$2b$/$2y$)
+type SocksAuthConfig struct {
+	Users     []SocksUser `mapstructure:"users"`      // 用户列表
+	UsersFile string      `mapstructure:"users_file"` // htpasswd 格式的用户文件 (每行 user:password)
+}
+
+// SocksUser SOCKS5 用户
+type SocksUser struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"` // 明文或 bcrypt 哈希
+}
+
+// Enabled 是否启用了认证
+func (a SocksAuthConfig) Enabled() bool {
+	return len(a.Users) > 0 || a.UsersFile != ""
+}
+
+// RemoteDynamicTunnel 反向动态端口转发配置 (-R port)
+// 在远程服务器上提供 SOCKS5 代理，目标由本地连接
+type RemoteDynamicTunnel struct {
+	Bind         string `mapstructure:"bind"`         // 远程SOCKS5监听地址 (例如: 127.0.0.1:1080)
+	Destinations ACL    `mapstructure:"destinations"` // 允许访问的本地目标，allow 不能为空 (允许所有目标: "*")
+	ConnLimits   `mapstructure:",squash"`
+}
+
+// PACConfig 代理自动配置 (PAC) 文件服务
+// 根据动态转发及其路由规则生成 proxy.pac
+type PACConfig struct {
+	Bind      string `mapstructure:"bind"`       // HTTP 监听地址 (例如: 127.0.0.1:8090)，为空则禁用
+	Tunnel    string `mapstructure:"tunnel"`     // 使用的动态转发监听地址 (默认: 第一个动态转发)
+	ProxyHost string `mapstructure:"proxy_host"` // PAC 中的代理主机 (默认取动态转发的监听地址)
+}
+
+// DNSConfig 本地 DNS 转发配置
+// 指定域名的查询通过SSH隧道以 TCP DNS 转发到远程网络中的 DNS 服务器
+type DNSConfig struct {
+	Bind      string        `mapstructure:"bind"`       // 本地监听地址 (UDP 和 TCP, 例如: 127.0.0.1:5353)，为空则禁用
+	Resolver  string        `mapstructure:"resolver"`   // 远程网络中的 DNS 服务器 (例如: 10.0.0.2:53)
+	Zones     []string      `mapstructure:"zones"`      // 通过隧道解析的域名后缀 (为空则全部通过隧道)
+	Fallback  string        `mapstructure:"fallback"`   // 其他域名使用的本地 DNS 服务器 (为空则拒绝查询)
+	CacheSize int           `mapstructure:"cache_size"` // 缓存条目数 (默认: 1024, 负数禁用缓存)
+	Timeout   time.Duration `mapstructure:"timeout"`    // 查询超时 (默认: 5s)
+}
+
+// BandwidthConfig 全局带宽限制和流量配额
+// 速率限制作用于所有隧道的总流量，与隧道各自的 upload_rate/download_rate 同时生效
+type BandwidthConfig struct {
+	UploadRate   string `mapstructure:"upload_rate"`   // 全局上传速率 (例如: 10MB)
+	DownloadRate string `mapstructure:"download_rate"` // 全局下载速率
+	DailyQuota   string `mapstructure:"daily_quota"`   // 每日流量配额 (上传+下载, 例如: 5GB)
+	MonthlyQuota string `mapstructure:"monthly_quota"` // 每月流量配额
+	QuotaFile    string `mapstructure:"quota_file"`    // 用量保存路径 (默认: ~/.autossh/quota.json)
+	QuotaAction  string `mapstructure:"quota_action"`  // 配额用尽时: reject (拒绝新连接, 默认) 或 pause (暂停所有转发)
+}
+
+// 配额用尽时的处理方式
+const (
+	QuotaReject = "reject"
+	QuotaPause  = "pause"
+)
+
+// QuotaEnabled 是否配置了流量配额
+func (b *BandwidthConfig) QuotaEnabled() bool {
+	return b.DailyQuota != "" || b.MonthlyQuota != ""
+}
+
+// Validate 检查带宽配置
+func (b *BandwidthConfig) Validate() error {
+	for _, size := range []string{b.UploadRate, b.DownloadRate, b.DailyQuota, b.MonthlyQuota} {
+		if _, err := ParseByteSize(size); err != nil {
+			return fmt.Errorf("无效的带宽配置: %w", err)
+		}
+	}
+	switch b.QuotaAction {
+	case "", QuotaReject, QuotaPause:
+	default:
+		return fmt.Errorf("无效的配额处理方式: %s (期望: reject 或 pause)", b.QuotaAction)
+	}
+	return nil
+}
+
+// RelayConfig 连接数据转发配置
+type RelayConfig struct {
+	BufferSize   string        `mapstructure:"buffer_size"`   // 每个方向的转发缓冲区大小 (例如: 64KB, 默认: 32KB)
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"` // 取消转发时等待现有连接结束的最长时间 (默认: 5s, 0 = 立即关闭)
+}
+
+// 转发缓冲区大小的默认值和范围
+const (
+	DefaultRelayBufferSize = 32 * 1024
+	minRelayBufferSize     = 1024
+	maxRelayBufferSize     = 16 * 1024 * 1024
+)
+
+// BufferBytes 返回转发缓冲区大小，未配置时返回默认值
+func (r *RelayConfig) BufferBytes() int {
+	size, _ := ParseByteSize(r.BufferSize)
+	if size <= 0 {
+		return DefaultRelayBufferSize
+	}
+	return int(size)
+}
+
+// Validate 检查转发配置
+func (r *RelayConfig) Validate() error {
+	size, err := ParseByteSize(r.BufferSize)
+	if err != nil {
+		return fmt.Errorf("无效的转发缓冲区大小: %w", err)
+	}
+	if size != 0 && (size < minRelayBufferSize || size > maxRelayBufferSize) {
+		return fmt.Errorf("转发缓冲区大小应在 1KB 到 16MB 之间: %s", r.BufferSize)
+	}
+	if r.DrainTimeout < 0 {
+		return fmt.Errorf("无效的排空时间: %s", r.DrainTimeout)
+	}
+	return nil
+}
+
+// ShutdownConfig 退出配置
+type ShutdownConfig struct {
+	GracePeriod time.Duration `mapstructure:"grace_period"` // 收到退出信号后等待现有连接结束的最长时间 (默认: 与 relay.drain_timeout 相同, 0 = 立即关闭)
+}
+
+// Validate 检查退出配置
+func (s *ShutdownConfig) Validate() error {
+	if s.GracePeriod < 0 {
+		return fmt.Errorf("无效的退出宽限期: %s", s.GracePeriod)
+	}
+	return nil
+}
+
+// SessionConfig 远程命令配置
+// 命令在每次连接建立后启动，连接断开时结束，重连后重新启动
+type SessionConfig struct {
+	Command       string   `mapstructure:"command"`         // 远程执行的命令，为空则不执行
+	PTY           bool     `mapstructure:"pty"`             // 分配伪终端 (stderr 合并到 stdout)
+	Env           []string `mapstructure:"env"`             // 环境变量 NAME=value (需服务器 AcceptEnv 允许)
+	Output        string   `mapstructure:"output"`          // 输出位置: log (写入日志, 默认)、discard 或文件路径 (追加)
+	ExitIsFailure bool     `mapstructure:"exit_is_failure"` // 命令退出时视为连接失败并重连
+}
+
+// 远程命令的输出位置
+const (
+	SessionOutputLog     = "log"
+	SessionOutputDiscard = "discard"
+)
+
+// Validate 检查远程命令配置
+func (s *SessionConfig) Validate() error {
+	if s.Command == "" {
+		if s.PTY || len(s.Env) > 0 || s.Output != "" || s.ExitIsFailure {
+			return fmt.Errorf("session 未指定 command")
+		}
+		return nil
+	}
+	for _, env := range s.Env {
+		if name, _, ok := strings.Cut(env, "="); !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("无效的环境变量: %q (期望: NAME=value)", env)
+		}
+	}
+	return nil
+}
+
+// ControlConfig 连接复用控制套接字 (类似 OpenSSH 的 ControlMaster)
+// 主连接在控制套接字上接受其他 autossh 进程的请求，在同一SSH连接上添加或取消转发
+type ControlConfig struct {
+	Path   string `mapstructure:"path"`   // 控制套接字路径，支持 %h (主机)、%p (端口)、%r (用户名)、%% 和 ~
+	Master string `mapstructure:"master"` // no (默认): 已有主连接时复用; yes: 作为主连接; auto: 已有主连接时复用，否则作为主连接
+}
+
+// 控制套接字的主连接模式
+const (
+	ControlMasterNo   = "no"
+	ControlMasterYes  = "yes"
+	ControlMasterAuto = "auto"
+)
+
+// Validate 检查控制套接字配置
+func (c *ControlConfig) Validate() error {
+	switch c.Master {
+	case "", ControlMasterNo:
+	case ControlMasterYes, ControlMasterAuto:
+		if c.Path == "" {
+			return fmt.Errorf("control.master 为 %s 时需要指定 control.path", c.Master)
+		}
+	default:
+		return fmt.Errorf("无效的 control.master: %s (期望: yes、no 或 auto)", c.Master)
+	}
+	return nil
+}
+
+// ControlPath 返回展开占位符后的控制套接字路径，未配置时返回空字符串
+func (c *Config) ControlPath() string {
+	if c.Control.Path == "" {
+		return ""
+	}
+	var b strings.Builder
+	path := c.Control.Path
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 == len(path) {
+			b.WriteByte(path[i])
+			continue
+		}
+		i++
+		switch path[i] {
+		case 'h':
+			b.WriteString(c.Server.Host)
+		case 'p':
+			b.WriteString(strconv.Itoa(c.Server.Port))
+		case 'r':
+			b.WriteString(c.Server.User)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(path[i])
+		}
+	}
+	return expandPath(b.String())
 }
 
 // ReconnectConfig 自动重连配置
@@ -79,7 +615,14 @@ func DefaultConfig() *Config {
 			Interval:   5 * time.Second,
 			MaxRetries: 0,
 		},
-		LogLevel: "info",
+		Relay: RelayConfig{
+			DrainTimeout: 5 * time.Second,
+		},
+		Shutdown: ShutdownConfig{
+			GracePeriod: 5 * time.Second,
+		},
+		LogLevel:    "info",
+		Connections: 1,
 	}
 }
 
@@ -109,94 +652,297 @@ func LoadFromFile(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
 
+	// 未配置退出宽限期时与排空时间相同
+	if !viper.IsSet("shutdown.grace_period") {
+		cfg.Shutdown.GracePeriod = cfg.Relay.DrainTimeout
+	}
+
+	// *:port 表示监听所有地址
+	for i := range cfg.Tunnels.Local {
+		cfg.Tunnels.Local[i].Bind = normalizeBind(cfg.Tunnels.Local[i].Bind)
+	}
+	for i := range cfg.Tunnels.Remote {
+		cfg.Tunnels.Remote[i].Bind = normalizeBind(cfg.Tunnels.Remote[i].Bind)
+	}
+	for i := range cfg.Tunnels.Dynamic {
+		cfg.Tunnels.Dynamic[i].Bind = normalizeBind(cfg.Tunnels.Dynamic[i].Bind)
+	}
+	for i := range cfg.Tunnels.RemoteDynamic {
+		cfg.Tunnels.RemoteDynamic[i].Bind = normalizeBind(cfg.Tunnels.RemoteDynamic[i].Bind)
+	}
+
+	// 展开端口范围
+	var err error
+	if cfg.Tunnels.Local, err = expandLocal(cfg.Tunnels.Local); err != nil {
+		return nil, err
+	}
+	if cfg.Tunnels.Remote, err = expandRemote(cfg.Tunnels.Remote); err != nil {
+		return nil, err
+	}
+
 	// 展开密钥文件路径中的 ~
 	if cfg.Auth.KeyFile != "" {
 		cfg.Auth.KeyFile = expandPath(cfg.Auth.KeyFile)
 	}
+	for i := range cfg.Tunnels.Local {
+		cfg.Tunnels.Local[i].TLS.expandPaths()
+	}
+	for i := range cfg.Tunnels.Dynamic {
+		if f := cfg.Tunnels.Dynamic[i].Auth.UsersFile; f != "" {
+			cfg.Tunnels.Dynamic[i].Auth.UsersFile = expandPath(f)
+		}
+		if f := cfg.Tunnels.Dynamic[i].Routing.RulesFile; f != "" {
+			cfg.Tunnels.Dynamic[i].Routing.RulesFile = expandPath(f)
+		}
+		cfg.Tunnels.Dynamic[i].TLS.expandPaths()
+	}
+	if cfg.StateFile != "" {
+		cfg.StateFile = expandPath(cfg.StateFile)
+	}
+	if cfg.Bandwidth.QuotaFile != "" {
+		cfg.Bandwidth.QuotaFile = expandPath(cfg.Bandwidth.QuotaFile)
+	}
+	if out := cfg.Session.Output; out != SessionOutputLog && out != SessionOutputDiscard {
+		cfg.Session.Output = expandPath(out)
+	}
 
 	return cfg, nil
 }
 
-// ParseTarget 解析 user@host:port 格式的目标地址
-func ParseTarget(target string) (user, host string, port int, err error) {
-	port = 22 // 默认端口
+// maxPortExpansion 单个端口范围或列表最多展开的端口数
+const maxPortExpansion = 1024
+
+// ParsePortList 解析端口、端口范围或逗号分隔的列表 (例如: 8080, 9000-9010, 80,443,8000-8002)
+func ParsePortList(s string) ([]int, error) {
+	var ports []int
+	for _, item := range strings.Split(s, ",") {
+		low, high, err := ParsePortRange(item)
+		if err != nil {
+			return nil, err
+		}
+		if high-low+1 > maxPortExpansion-len(ports) {
+			return nil, fmt.Errorf("端口数量超过 %d: %s", maxPortExpansion, s)
+		}
+		for port := low; port <= high; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// splitPortList 拆分地址中的主机和端口列表
+// 端口不是范围或列表 (包括 Unix 套接字) 时 ports 为 nil
+func splitPortList(addr string) (host string, ports []int, err error) {
+	if IsUnixSocket(addr) {
+		return addr, nil, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.ContainsAny(port, ",-") {
+		return addr, nil, nil
+	}
+	ports, err = ParsePortList(port)
+	if err != nil {
+		return "", nil, fmt.Errorf("无效的端口列表 %s: %w", addr, err)
+	}
+	return host, ports, nil
+}
 
-	// 解析 user@host:port
-	if idx := strings.Index(target, "@"); idx != -1 {
-		user = target[:idx]
-		target = target[idx+1:]
+// expandForward 将监听端和目标端的端口范围展开为一一对应的地址
+// 目标端为单个端口或 Unix 套接字时，所有监听端口转发到同一目标
+func expandForward(bind, target string) ([][2]string, error) {
+	bindHost, bindPorts, err := splitPortList(bind)
+	if err != nil {
+		return nil, err
+	}
+	targetHost, targetPorts, err := splitPortList(target)
+	if err != nil {
+		return nil, err
 	}
 
-	// 解析 host:port
-	if idx := strings.LastIndex(target, ":"); idx != -1 {
-		host = target[:idx]
-		_, err = fmt.Sscanf(target[idx+1:], "%d", &port)
+	if bindPorts == nil {
+		if targetPorts != nil {
+			return nil, fmt.Errorf("目标端口为范围时监听端口也必须为范围: %s -> %s", bind, target)
+		}
+		return [][2]string{{bind, target}}, nil
+	}
+	if targetPorts != nil && len(targetPorts) != len(bindPorts) {
+		return nil, fmt.Errorf("监听端口数量 (%d) 与目标端口数量 (%d) 不一致: %s -> %s",
+			len(bindPorts), len(targetPorts), bind, target)
+	}
+
+	pairs := make([][2]string, 0, len(bindPorts))
+	for i, port := range bindPorts {
+		t := target
+		if targetPorts != nil {
+			t = net.JoinHostPort(targetHost, strconv.Itoa(targetPorts[i]))
+		}
+		pairs = append(pairs, [2]string{net.JoinHostPort(bindHost, strconv.Itoa(port)), t})
+	}
+	return pairs, nil
+}
+
+// expandLocal 展开本地转发中的端口范围，未展开的隧道保留原名称
+func expandLocal(tunnels []LocalTunnel) ([]LocalTunnel, error) {
+	var expanded []LocalTunnel
+	for _, t := range tunnels {
+		pairs, err := expandForward(t.Bind, t.Target)
 		if err != nil {
-			return "", "", 0, fmt.Errorf("无效的端口号: %s", target[idx+1:])
+			return nil, err
+		}
+		if len(pairs) > 1 && t.Name == "" {
+			t.Name = t.Bind + " -> " + t.Target
+		}
+		for _, pair := range pairs {
+			t.Bind, t.Target = pair[0], pair[1]
+			expanded = append(expanded, t)
 		}
-	} else {
-		host = target
 	}
+	return expanded, nil
+}
 
-	if host == "" {
-		return "", "", 0, fmt.Errorf("未指定主机")
+// expandRemote 展开远程转发中的端口范围，未展开的隧道保留原名称
+func expandRemote(tunnels []RemoteTunnel) ([]RemoteTunnel, error) {
+	var expanded []RemoteTunnel
+	for _, t := range tunnels {
+		pairs, err := expandForward(t.Bind, t.Target)
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs) > 1 && t.Name == "" {
+			t.Name = t.Bind + " -> " + t.Target
+		}
+		for _, pair := range pairs {
+			t.Bind, t.Target = pair[0], pair[1]
+			expanded = append(expanded, t)
+		}
 	}
+	return expanded, nil
+}
 
-	return user, host, port, nil
+// IsUnixSocket 判断地址是否为 Unix 套接字路径
+func IsUnixSocket(addr string) bool {
+	return strings.Contains(addr, "/")
 }
 
-// ParseLocalForward 解析本地转发参数 (-L)
-// 格式: [bind_address:]port:host:hostport
-func ParseLocalForward(spec string) (*LocalTunnel, error) {
-	parts := strings.Split(spec, ":")
-	switch len(parts) {
-	case 3:
-		// port:host:hostport
-		return &LocalTunnel{
-			Bind:   "127.0.0.1:" + parts[0],
-			Target: parts[1] + ":" + parts[2],
-		}, nil
-	case 4:
-		// bind_address:port:host:hostport
-		return &LocalTunnel{
-			Bind:   parts[0] + ":" + parts[1],
-			Target: parts[2] + ":" + parts[3],
-		}, nil
+// Network 返回地址对应的网络类型 ("unix" 或 "tcp")
+func Network(addr string) string {
+	if IsUnixSocket(addr) {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// validateEntries 验证目标访问控制条目
+func (a ACL) validateEntries() error {
+	switch a.Resolve {
+	case "", "remote", "local":
 	default:
-		return nil, fmt.Errorf("无效的本地转发格式: %s (期望: [bind_address:]port:host:hostport)", spec)
-	}
-}
-
-// ParseRemoteForward 解析远程转发参数 (-R)
-// 格式: [bind_address:]port:host:hostport
-func ParseRemoteForward(spec string) (*RemoteTunnel, error) {
-	parts := strings.Split(spec, ":")
-	switch len(parts) {
-	case 3:
-		// port:host:hostport
-		return &RemoteTunnel{
-			Bind:   "0.0.0.0:" + parts[0],
-			Target: parts[1] + ":" + parts[2],
-		}, nil
-	case 4:
-		// bind_address:port:host:hostport
-		return &RemoteTunnel{
-			Bind:   parts[0] + ":" + parts[1],
-			Target: parts[2] + ":" + parts[3],
-		}, nil
+		return fmt.Errorf("无效的目标域名解析位置: %s (可选: remote, local)", a.Resolve)
+	}
+	for _, list := range [][]string{a.Allow, a.Deny} {
+		for _, entry := range list {
+			if _, err := ParseACLEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validatePrefixes 验证来源访问控制中的 CIDR 和 IP
+func (a ACL) validatePrefixes() error {
+	for _, list := range [][]string{a.Allow, a.Deny} {
+		for _, network := range list {
+			if _, err := ParsePrefix(network); err != nil {
+				return fmt.Errorf("无效的来源地址 %s: %w", network, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParsePrefix 解析 CIDR 或单个 IP 地址
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ParsePortRange 解析端口或端口范围 (例如: 443, 8000-9000)
+func ParsePortRange(s string) (low, high int, err error) {
+	lowStr, highStr, isRange := strings.Cut(s, "-")
+	low, err = strconv.Atoi(strings.TrimSpace(lowStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的端口: %s", s)
+	}
+	high = low
+	if isRange {
+		high, err = strconv.Atoi(strings.TrimSpace(highStr))
+		if err != nil {
+			return 0, 0, fmt.Errorf("无效的端口: %s", s)
+		}
+	}
+	if low < 0 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("无效的端口范围: %s", s)
+	}
+	return low, high, nil
+}
+
+// ParseByteSize 解析字节数 (例如: 1024, 512K, 1.5MB, 10GiB)，单位按 1024 进制
+// 空字符串返回 0
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("无效的字节数: %s", s)
+	}
+
+	var multiplier float64
+	unit = strings.ToUpper(unit)
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "IB"), "B") {
+	case "":
+		multiplier = 1
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
 	default:
-		return nil, fmt.Errorf("无效的远程转发格式: %s (期望: [bind_address:]port:host:hostport)", spec)
+		return 0, fmt.Errorf("无效的字节数单位: %s", s)
 	}
+	return int64(value * multiplier), nil
 }
 
-// ParseDynamicForward 解析动态转发参数 (-D)
-// 格式: [bind_address:]port
-func ParseDynamicForward(spec string) (*DynamicTunnel, error) {
-	if strings.Contains(spec, ":") {
-		return &DynamicTunnel{Bind: spec}, nil
+// ParseFileMode 解析八进制文件权限 (例如: "0660")
+func ParseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
 	}
-	// 只有端口号
-	return &DynamicTunnel{Bind: "127.0.0.1:" + spec}, nil
+	if mode > 0o777 {
+		return 0, fmt.Errorf("权限超出范围")
+	}
+	return os.FileMode(mode), nil
 }
 
 // expandPath 展开路径中的 ~ 为用户主目录
@@ -211,6 +957,84 @@ func expandPath(path string) string {
 	return path
 }
 
+// Count 返回隧道数量
+func (c *TunnelsConfig) Count() int {
+	return len(c.Local) + len(c.Remote) + len(c.Dynamic) + len(c.RemoteDynamic)
+}
+
+// Validate 验证各隧道的配置
+func (c *TunnelsConfig) Validate() error {
+	for _, t := range c.Local {
+		if t.SocketMode != "" {
+			if _, err := ParseFileMode(t.SocketMode); err != nil {
+				return fmt.Errorf("无效的套接字权限 %s: %w", t.SocketMode, err)
+			}
+		}
+		if (t.SocketMode != "" || t.SocketOwner != "") && !IsUnixSocket(t.Bind) {
+			return fmt.Errorf("socket_mode/socket_owner 仅适用于 Unix 套接字监听: %s", t.Bind)
+		}
+		if err := t.Sources.validatePrefixes(); err != nil {
+			return err
+		}
+		if err := t.ConnLimits.validate(t.Bind); err != nil {
+			return err
+		}
+		if err := validateProxyProtocol(t.ProxyProtocol); err != nil {
+			return err
+		}
+		if err := t.TLS.validate(t.Bind); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range c.Remote {
+		if err := t.ConnLimits.validate(t.Bind); err != nil {
+			return err
+		}
+		if err := validateProxyProtocol(t.ProxyProtocol); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range c.Dynamic {
+		for _, u := range t.Auth.Users {
+			if u.Username == "" || len(u.Username) > 255 || len(u.Password) > 255 {
+				return fmt.Errorf("无效的SOCKS5用户: %q (用户名不能为空, 用户名和密码长度不能超过255)", u.Username)
+			}
+		}
+		if err := t.Routing.Validate(t.Routing.Rules); err != nil {
+			return err
+		}
+		if err := t.Destinations.validateEntries(); err != nil {
+			return err
+		}
+		if err := t.Sources.validatePrefixes(); err != nil {
+			return err
+		}
+		if err := t.ConnLimits.validate(t.Bind); err != nil {
+			return err
+		}
+		if err := t.TLS.validate(t.Bind); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range c.RemoteDynamic {
+		// 远程服务器上能连接监听地址的用户都可以访问本地网络，必须显式允许
+		if len(t.Destinations.Allow) == 0 {
+			return fmt.Errorf("远程SOCKS5代理 %s 未配置 destinations.allow (允许所有目标: \"*\"，命令行使用 --remote-allow)", t.Bind)
+		}
+		if err := t.Destinations.validateEntries(); err != nil {
+			return err
+		}
+		if err := t.ConnLimits.validate(t.Bind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Validate 验证配置的有效性
 func (c *Config) Validate() error {
 	if c.Server.Host == "" {
@@ -222,6 +1046,9 @@ func (c *Config) Validate() error {
 	if c.Server.Port <= 0 || c.Server.Port > 65535 {
 		return fmt.Errorf("无效的端口号: %d", c.Server.Port)
 	}
+	if c.Connections < 1 {
+		return fmt.Errorf("无效的连接数: %d", c.Connections)
+	}
 
 	switch c.Auth.Type {
 	case "password":
@@ -238,16 +1065,63 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("无效的认证类型: %s (期望: password 或 key)", c.Auth.Type)
 	}
 
-	// 检查是否有至少一个隧道配置
-	if len(c.Tunnels.Local) == 0 && len(c.Tunnels.Remote) == 0 && len(c.Tunnels.Dynamic) == 0 {
+	// 检查是否有至少一个隧道配置 (-W 模式、只执行远程命令和主连接除外，主连接的转发可通过控制套接字添加)
+	master := c.Control.Master == ControlMasterYes || c.Control.Master == ControlMasterAuto
+	if c.Stdio == "" && c.Session.Command == "" && !master && c.Tunnels.Count() == 0 {
 		return fmt.Errorf("未配置任何隧道")
 	}
 
+	if err := c.Tunnels.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Bandwidth.Validate(); err != nil {
+		return err
+	}
+	if err := c.Relay.Validate(); err != nil {
+		return err
+	}
+	if err := c.Shutdown.Validate(); err != nil {
+		return err
+	}
+	if err := c.Session.Validate(); err != nil {
+		return err
+	}
+	if err := c.Control.Validate(); err != nil {
+		return err
+	}
+
+	if c.DNS.Bind != "" && c.DNS.Resolver == "" {
+		return fmt.Errorf("DNS 转发未指定远程 DNS 服务器 (dns.resolver)")
+	}
+
+	if c.PAC.Bind != "" {
+		if _, err := c.PACTunnel(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// PACTunnel 返回 PAC 文件使用的动态转发
+func (c *Config) PACTunnel() (*DynamicTunnel, error) {
+	if len(c.Tunnels.Dynamic) == 0 {
+		return nil, fmt.Errorf("PAC 服务需要至少一个动态转发")
+	}
+	if c.PAC.Tunnel == "" {
+		return &c.Tunnels.Dynamic[0], nil
+	}
+	for i := range c.Tunnels.Dynamic {
+		if c.Tunnels.Dynamic[i].Bind == c.PAC.Tunnel {
+			return &c.Tunnels.Dynamic[i], nil
+		}
+	}
+	return nil, fmt.Errorf("PAC 服务指定的动态转发不存在: %s", c.PAC.Tunnel)
+}
+
 // Address 返回服务器地址
 func (c *Config) Address() string {
-	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
+	return net.JoinHostPort(c.Server.Host, strconv.Itoa(c.Server.Port))
 }
 
@@ -0,0 +1,419 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 命令行转发参数的语法与 OpenSSH 一致:
+//
+//   - 字段以 ':' 分隔，IPv6 地址需使用方括号，例如 [::1]:8080:[fe80::1]:80
+//   - 也可使用 '/' 作为分隔符 (OpenSSH 备用语法)，此时 IPv6 地址无需方括号，例如 ::1/8080/fe80::1/80
+//   - 监听地址为空或 '*' 表示监听所有地址，主机名 (包括 localhost) 由系统解析后监听
+//   - 包含 '/' 的字段为 Unix 套接字路径
+
+// serviceName 目标端口位置允许使用的服务名 (例如: http)
+var serviceName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// specField 转发参数中的一个字段
+type specField struct {
+	value     string
+	bracketed bool // 使用方括号包围，例如 [::1]
+}
+
+// isPath 字段是否为 Unix 套接字路径
+func (f specField) isPath() bool {
+	return !f.bracketed && IsUnixSocket(f.value)
+}
+
+// altLayout 备用语法中各字段数对应的端口字段位置
+type altLayout map[int][]int
+
+var (
+	// forwardLayout -L/-R: port/host/hostport 或 bind_address/port/host/hostport
+	forwardLayout = altLayout{3: {0, 2}, 4: {1, 3}}
+
+	// bindLayout -D 及反向动态转发: bind_address/port
+	bindLayout = altLayout{2: {1}}
+)
+
+// ParseTarget 解析 user@host:port 格式的目标地址
+// IPv6 地址指定端口时需使用方括号，例如 user@[::1]:2222
+func ParseTarget(target string) (user, host string, port int, err error) {
+	port = 22 // 默认端口
+
+	// 用户名中可能包含 '@'，以最后一个为准
+	if idx := strings.LastIndex(target, "@"); idx != -1 {
+		if idx == 0 {
+			return "", "", 0, fmt.Errorf("用户名为空: %s", target)
+		}
+		user = target[:idx]
+		target = target[idx+1:]
+	}
+
+	portStr := ""
+	switch {
+	case strings.HasPrefix(target, "["):
+		end := strings.IndexByte(target, ']')
+		if end == -1 {
+			return "", "", 0, fmt.Errorf("缺少与 '[' 匹配的 ']': %s", target)
+		}
+		host = target[1:end]
+		if rest := target[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return "", "", 0, fmt.Errorf("']' 之后只能是 :port: %s", target)
+			}
+			portStr = rest[1:]
+			if portStr == "" {
+				return "", "", 0, fmt.Errorf("端口号为空: %s", target)
+			}
+		}
+	case strings.Count(target, ":") > 1:
+		// 未加方括号的 IPv6 地址，不能指定端口
+		if _, err := netip.ParseAddr(target); err != nil {
+			return "", "", 0, fmt.Errorf("无效的主机地址: %s (IPv6 地址指定端口时需使用方括号，例如 [::1]:22)", target)
+		}
+		host = target
+	default:
+		var hasPort bool
+		host, portStr, hasPort = strings.Cut(target, ":")
+		if hasPort && portStr == "" {
+			return "", "", 0, fmt.Errorf("端口号为空: %s", target)
+		}
+	}
+
+	if strings.ContainsAny(host, "[]/") {
+		return "", "", 0, fmt.Errorf("无效的主机地址: %s", host)
+	}
+	if host == "" {
+		return "", "", 0, fmt.Errorf("未指定主机")
+	}
+
+	if portStr != "" {
+		port, err = strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return "", "", 0, fmt.Errorf("无效的端口号: %s", portStr)
+		}
+	}
+
+	return user, host, port, nil
+}
+
+// ParseLocalForward 解析本地转发参数 (-L)
+// 格式: [bind_address:]port:host:hostport
+//
+//	[bind_address:]port:remote_socket
+//	local_socket:host:hostport
+//	local_socket:remote_socket
+//
+// port 和 hostport 可为端口范围或列表 (例如: 9000-9010, 80,443)，展开为多个隧道
+func ParseLocalForward(spec string) ([]LocalTunnel, error) {
+	bind, target, err := parseForwardSpec(spec, "127.0.0.1")
+	if err != nil {
+		return nil, fmt.Errorf("无效的本地转发 %q: %w (期望: [bind_address:]port:host:hostport 或 Unix 套接字路径)", spec, err)
+	}
+	tunnels, err := expandLocal([]LocalTunnel{{Name: spec, Bind: bind, Target: target}})
+	if err != nil {
+		return nil, fmt.Errorf("无效的本地转发 %q: %w", spec, err)
+	}
+	return tunnels, nil
+}
+
+// ParseRemoteForward 解析远程转发参数 (-R)
+// 格式: [bind_address:]port:host:hostport
+//
+//	[bind_address:]port:local_socket
+//	remote_socket:host:hostport
+//	remote_socket:local_socket
+//
+// port 和 hostport 可为端口范围或列表 (例如: 9000-9010, 80,443)，展开为多个隧道
+func ParseRemoteForward(spec string) ([]RemoteTunnel, error) {
+	bind, target, err := parseForwardSpec(spec, "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("无效的远程转发 %q: %w (期望: [bind_address:]port:host:hostport 或 Unix 套接字路径)", spec, err)
+	}
+	tunnels, err := expandRemote([]RemoteTunnel{{Name: spec, Bind: bind, Target: target}})
+	if err != nil {
+		return nil, fmt.Errorf("无效的远程转发 %q: %w", spec, err)
+	}
+	return tunnels, nil
+}
+
+// IsRemoteDynamicSpec 判断 -R 参数是否为反向动态转发 ([bind_address:]port)
+func IsRemoteDynamicSpec(spec string) bool {
+	fields, err := splitSpec(spec, bindLayout)
+	if err != nil || len(fields) > 2 || fields[0].isPath() {
+		return false
+	}
+	last := fields[len(fields)-1]
+	if last.bracketed {
+		return false
+	}
+	_, err = strconv.Atoi(last.value)
+	return err == nil
+}
+
+// ParseRemoteDynamicForward 解析反向动态转发参数 (-R)
+// 格式: [bind_address:]port
+func ParseRemoteDynamicForward(spec string) (*RemoteDynamicTunnel, error) {
+	// 只有端口号时默认只在远程回环地址监听
+	bind, err := parseBindSpec(spec, "127.0.0.1")
+	if err != nil {
+		return nil, fmt.Errorf("无效的反向动态转发 %q: %w (期望: [bind_address:]port)", spec, err)
+	}
+	return &RemoteDynamicTunnel{Bind: bind}, nil
+}
+
+// ParseDynamicForward 解析动态转发参数 (-D)
+// 格式: [bind_address:]port
+func ParseDynamicForward(spec string) (*DynamicTunnel, error) {
+	bind, err := parseBindSpec(spec, "127.0.0.1")
+	if err != nil {
+		return nil, fmt.Errorf("无效的动态转发 %q: %w (期望: [bind_address:]port)", spec, err)
+	}
+	return &DynamicTunnel{Bind: bind}, nil
+}
+
+// ParseStdioForward 解析标准输入输出转发参数 (-W)
+// 格式: host:port 或 Unix 套接字路径
+func ParseStdioForward(spec string) (string, error) {
+	fields, err := splitSpec(spec, nil)
+	if err != nil {
+		return "", fmt.Errorf("无效的标准输入输出转发 %q: %w (期望: host:port)", spec, err)
+	}
+	if len(fields) == 1 && fields[0].isPath() {
+		return fields[0].value, nil
+	}
+	if len(fields) != 2 || strings.ContainsAny(fields[1].value, ",-") {
+		return "", fmt.Errorf("无效的标准输入输出转发 %q (期望: host:port，IPv6 地址需使用方括号)", spec)
+	}
+	target, err := joinTarget(fields[0], fields[1])
+	if err != nil {
+		return "", fmt.Errorf("无效的标准输入输出转发 %q: %w (期望: host:port)", spec, err)
+	}
+	return target, nil
+}
+
+// parseForwardSpec 将转发参数拆分为监听端和目标端
+// 监听端和目标端都可以是 Unix 套接字路径
+func parseForwardSpec(spec, defaultBind string) (bind, target string, err error) {
+	fields, err := splitSpec(spec, forwardLayout)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 目标端: Unix 套接字路径或 host:hostport
+	var rest []specField
+	if last := fields[len(fields)-1]; last.isPath() {
+		target = last.value
+		rest = fields[:len(fields)-1]
+	} else {
+		if len(fields) < 3 {
+			return "", "", errors.New("缺少目标主机或端口")
+		}
+		target, err = joinTarget(fields[len(fields)-2], last)
+		if err != nil {
+			return "", "", err
+		}
+		rest = fields[:len(fields)-2]
+	}
+
+	// 监听端: Unix 套接字路径、port 或 bind_address:port
+	switch len(rest) {
+	case 0:
+		return "", "", errors.New("缺少监听端口")
+	case 1:
+		if rest[0].isPath() {
+			bind = rest[0].value
+		} else {
+			bind, err = joinBind(specField{value: defaultBind}, rest[0])
+		}
+	case 2:
+		bind, err = joinBind(rest[0], rest[1])
+	default:
+		return "", "", errors.New("字段过多 (IPv6 地址需使用方括号，例如 [::1])")
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return bind, target, nil
+}
+
+// parseBindSpec 解析 [bind_address:]port 格式的监听地址，只指定端口时使用 defaultBind
+func parseBindSpec(spec, defaultBind string) (string, error) {
+	fields, err := splitSpec(spec, bindLayout)
+	if err != nil {
+		return "", err
+	}
+
+	var host, port specField
+	switch len(fields) {
+	case 1:
+		host, port = specField{value: defaultBind}, fields[0]
+	case 2:
+		host, port = fields[0], fields[1]
+	default:
+		return "", errors.New("字段过多 (IPv6 地址需使用方括号，例如 [::1])")
+	}
+
+	if p, err := strconv.Atoi(port.value); err != nil || port.bracketed || p < 0 || p > 65535 {
+		return "", fmt.Errorf("无效的监听端口: %q", port.value)
+	}
+	return joinBind(host, port)
+}
+
+// splitSpec 拆分转发参数的字段
+// 符合 layout 的 '/' 分隔参数按备用语法拆分，否则按 ':' 拆分
+func splitSpec(spec string, layout altLayout) ([]specField, error) {
+	if spec == "" {
+		return nil, errors.New("参数为空")
+	}
+	if fields, ok := splitAlt(spec, layout); ok {
+		return fields, nil
+	}
+	return splitColon(spec)
+}
+
+// splitAlt 按 '/' 拆分 (OpenSSH 备用语法)
+// 要求没有方括号、所有字段非空且端口位置为数字，以免与 Unix 套接字路径混淆
+func splitAlt(spec string, layout altLayout) ([]specField, bool) {
+	if !strings.Contains(spec, "/") || strings.ContainsAny(spec, "[]") {
+		return nil, false
+	}
+	parts := strings.Split(spec, "/")
+	ports, ok := layout[len(parts)]
+	if !ok {
+		return nil, false
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil, false
+		}
+	}
+	for _, i := range ports {
+		if !isPortList(parts[i]) {
+			return nil, false
+		}
+	}
+
+	fields := make([]specField, len(parts))
+	for i, p := range parts {
+		// 包含 ':' 的字段只能是 IPv6 地址，与方括号写法等价
+		fields[i] = specField{value: p, bracketed: strings.Contains(p, ":")}
+	}
+	return fields, true
+}
+
+// splitColon 按 ':' 拆分，方括号内的 ':' 不作为分隔符
+func splitColon(spec string) ([]specField, error) {
+	var fields []specField
+	for {
+		if strings.HasPrefix(spec, "[") {
+			end := strings.IndexByte(spec, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("缺少与 '[' 匹配的 ']'")
+			}
+			value, rest := spec[1:end], spec[end+1:]
+			if value == "" {
+				return nil, errors.New("方括号内的地址为空")
+			}
+			if strings.ContainsAny(value, "[") {
+				return nil, fmt.Errorf("无效的地址: [%s]", value)
+			}
+			fields = append(fields, specField{value: value, bracketed: true})
+			if rest == "" {
+				return fields, nil
+			}
+			if rest[0] != ':' {
+				return nil, fmt.Errorf("']' 之后应为 ':'，实际为 %q", rest)
+			}
+			spec = rest[1:]
+			continue
+		}
+
+		value, rest, more := strings.Cut(spec, ":")
+		if strings.ContainsAny(value, "[]") {
+			return nil, fmt.Errorf("方括号位置错误: %q (方括号只能包围整个字段)", value)
+		}
+		fields = append(fields, specField{value: value})
+		if !more {
+			return fields, nil
+		}
+		spec = rest
+	}
+}
+
+// joinBind 组合监听地址，地址为空或 '*' 时监听所有地址
+func joinBind(host, port specField) (string, error) {
+	if port.bracketed || !isPortList(port.value) {
+		return "", fmt.Errorf("无效的监听端口: %q", port.value)
+	}
+	if _, err := ParsePortList(port.value); err != nil {
+		return "", fmt.Errorf("无效的监听端口: %w", err)
+	}
+
+	addr := host.value
+	if !host.bracketed && addr == "*" {
+		addr = ""
+	}
+	// 方括号内的 '/' 会使地址被当作 Unix 套接字路径
+	if strings.ContainsAny(addr, "*/") {
+		return "", fmt.Errorf("无效的监听地址: %q", host.value)
+	}
+	return net.JoinHostPort(addr, port.value), nil
+}
+
+// joinTarget 组合目标地址，端口可以是数字、端口范围/列表或服务名
+func joinTarget(host, port specField) (string, error) {
+	if host.value == "" {
+		return "", errors.New("目标主机为空")
+	}
+	if strings.ContainsAny(host.value, "*/") {
+		return "", fmt.Errorf("无效的目标主机: %q", host.value)
+	}
+
+	switch {
+	case port.bracketed:
+		return "", fmt.Errorf("无效的目标端口: [%s]", port.value)
+	case isPortList(port.value):
+		ports, err := ParsePortList(port.value)
+		if err != nil {
+			return "", fmt.Errorf("无效的目标端口: %w", err)
+		}
+		for _, p := range ports {
+			if p == 0 {
+				return "", fmt.Errorf("无效的目标端口: %q (端口不能为 0)", port.value)
+			}
+		}
+	case !serviceName.MatchString(port.value):
+		return "", fmt.Errorf("无效的目标端口: %q", port.value)
+	}
+	return net.JoinHostPort(host.value, port.value), nil
+}
+
+// isPortList 是否为数字端口、端口范围或列表的形式 (不校验取值)
+func isPortList(s string) bool {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && c != '-' && c != ',' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeBind 将配置文件中 *:port 形式的监听地址转换为监听所有地址
+func normalizeBind(addr string) string {
+	if rest, ok := strings.CutPrefix(addr, "*:"); ok {
+		return ":" + rest
+	}
+	return addr
+}
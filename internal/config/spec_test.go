@@ -0,0 +1,313 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+const (
+	forwardHint = " (期望: [bind_address:]port:host:hostport 或 Unix 套接字路径)"
+	bindHint    = " (期望: [bind_address:]port)"
+)
+
+// forwardCase 转发参数的测试用例，err 非空时为期望的完整错误信息
+type forwardCase struct {
+	spec  string
+	binds []string
+	dests []string
+	err   string
+}
+
+var localCases = []forwardCase{
+	{spec: "8080:example.com:80", binds: []string{"127.0.0.1:8080"}, dests: []string{"example.com:80"}},
+	{spec: "0.0.0.0:8080:example.com:80", binds: []string{"0.0.0.0:8080"}, dests: []string{"example.com:80"}},
+	{spec: "*:8080:example.com:80", binds: []string{":8080"}, dests: []string{"example.com:80"}},
+	{spec: ":8080:example.com:80", binds: []string{":8080"}, dests: []string{"example.com:80"}},
+	{spec: "localhost:8080:example.com:80", binds: []string{"localhost:8080"}, dests: []string{"example.com:80"}},
+	{spec: "8080:example.com:http", binds: []string{"127.0.0.1:8080"}, dests: []string{"example.com:http"}},
+	{spec: "[::1]:8080:[fe80::1]:80", binds: []string{"[::1]:8080"}, dests: []string{"[fe80::1]:80"}},
+	{spec: "8080:[2001:db8::1]:80", binds: []string{"127.0.0.1:8080"}, dests: []string{"[2001:db8::1]:80"}},
+	{spec: "::1/8080/fe80::1/80", binds: []string{"[::1]:8080"}, dests: []string{"[fe80::1]:80"}},
+	{spec: "8080/example.com/80", binds: []string{"127.0.0.1:8080"}, dests: []string{"example.com:80"}},
+	{spec: "/tmp/local.sock:example.com:80", binds: []string{"/tmp/local.sock"}, dests: []string{"example.com:80"}},
+	{spec: "8080:/var/run/remote.sock", binds: []string{"127.0.0.1:8080"}, dests: []string{"/var/run/remote.sock"}},
+	{spec: "/tmp/local.sock:/var/run/remote.sock", binds: []string{"/tmp/local.sock"}, dests: []string{"/var/run/remote.sock"}},
+	{spec: "9000-9001:example.com:80-81",
+		binds: []string{"127.0.0.1:9000", "127.0.0.1:9001"}, dests: []string{"example.com:80", "example.com:81"}},
+	{spec: "9000,9002:example.com:80",
+		binds: []string{"127.0.0.1:9000", "127.0.0.1:9002"}, dests: []string{"example.com:80", "example.com:80"}},
+
+	{spec: "", err: `无效的本地转发 "": 参数为空` + forwardHint},
+	{spec: "8080:example.com", err: `无效的本地转发 "8080:example.com": 缺少目标主机或端口` + forwardHint},
+	{spec: "example.com:80", err: `无效的本地转发 "example.com:80": 缺少目标主机或端口` + forwardHint},
+	{spec: "::1:8080:example.com:80", err: `无效的本地转发 "::1:8080:example.com:80": 字段过多 (IPv6 地址需使用方括号，例如 [::1])` + forwardHint},
+	{spec: "[::1:8080:example.com:80", err: `无效的本地转发 "[::1:8080:example.com:80": 缺少与 '[' 匹配的 ']'` + forwardHint},
+	{spec: "[]:8080:example.com:80", err: `无效的本地转发 "[]:8080:example.com:80": 方括号内的地址为空` + forwardHint},
+	{spec: "[::1]8080:example.com:80", err: `无效的本地转发 "[::1]8080:example.com:80": ']' 之后应为 ':'，实际为 "8080:example.com:80"` + forwardHint},
+	{spec: "a[b]:8080:example.com:80", err: `无效的本地转发 "a[b]:8080:example.com:80": 方括号位置错误: "a[b]" (方括号只能包围整个字段)` + forwardHint},
+	{spec: "[*]:8080:example.com:80", err: `无效的本地转发 "[*]:8080:example.com:80": 无效的监听地址: "*"` + forwardHint},
+	{spec: "[8080]:example.com:80", err: `无效的本地转发 "[8080]:example.com:80": 无效的监听端口: "8080"` + forwardHint},
+	{spec: "http:example.com:80", err: `无效的本地转发 "http:example.com:80": 无效的监听端口: "http"` + forwardHint},
+	{spec: "[/tmp/a]:8080:example.com:80", err: `无效的本地转发 "[/tmp/a]:8080:example.com:80": 无效的监听地址: "/tmp/a"` + forwardHint},
+	{spec: "8080:[/tmp/b]:80", err: `无效的本地转发 "8080:[/tmp/b]:80": 无效的目标主机: "/tmp/b"` + forwardHint},
+	{spec: "8080:*:80", err: `无效的本地转发 "8080:*:80": 无效的目标主机: "*"` + forwardHint},
+	{spec: "8080::80", err: `无效的本地转发 "8080::80": 目标主机为空` + forwardHint},
+	{spec: "8080:example.com:0", err: `无效的本地转发 "8080:example.com:0": 无效的目标端口: "0" (端口不能为 0)` + forwardHint},
+	{spec: "8080:example.com:80x", err: `无效的本地转发 "8080:example.com:80x": 无效的目标端口: "80x"` + forwardHint},
+	{spec: "70000:example.com:80", err: `无效的本地转发 "70000:example.com:80": 无效的监听端口: 无效的端口范围: 70000` + forwardHint},
+	{spec: "8080:example.com:80-81",
+		err: `无效的本地转发 "8080:example.com:80-81": 目标端口为范围时监听端口也必须为范围: 127.0.0.1:8080 -> example.com:80-81`},
+	{spec: "9000-9001:example.com:80-82",
+		err: `无效的本地转发 "9000-9001:example.com:80-82": 监听端口数量 (2) 与目标端口数量 (3) 不一致: 127.0.0.1:9000-9001 -> example.com:80-82`},
+}
+
+var remoteCases = []forwardCase{
+	{spec: "8080:localhost:80", binds: []string{"0.0.0.0:8080"}, dests: []string{"localhost:80"}},
+	{spec: "localhost:8080:localhost:80", binds: []string{"localhost:8080"}, dests: []string{"localhost:80"}},
+	{spec: "*:8080:localhost:80", binds: []string{":8080"}, dests: []string{"localhost:80"}},
+	{spec: "[::]:8080:[::1]:80", binds: []string{"[::]:8080"}, dests: []string{"[::1]:80"}},
+	{spec: "::/8080/::1/80", binds: []string{"[::]:8080"}, dests: []string{"[::1]:80"}},
+	{spec: "8080/localhost/80", binds: []string{"0.0.0.0:8080"}, dests: []string{"localhost:80"}},
+	{spec: "/tmp/remote.sock:127.0.0.1:80", binds: []string{"/tmp/remote.sock"}, dests: []string{"127.0.0.1:80"}},
+	{spec: "8080:/tmp/local.sock", binds: []string{"0.0.0.0:8080"}, dests: []string{"/tmp/local.sock"}},
+
+	{spec: "", err: `无效的远程转发 "": 参数为空` + forwardHint},
+	{spec: "8080", err: `无效的远程转发 "8080": 缺少目标主机或端口` + forwardHint},
+	{spec: "[::]:8080:::1:80", err: `无效的远程转发 "[::]:8080:::1:80": 字段过多 (IPv6 地址需使用方括号，例如 [::1])` + forwardHint},
+	{spec: "8080:localhost:[80]", err: `无效的远程转发 "8080:localhost:[80]": 无效的目标端口: [80]` + forwardHint},
+	{spec: "8080-8081:localhost:80-82",
+		err: `无效的远程转发 "8080-8081:localhost:80-82": 监听端口数量 (2) 与目标端口数量 (3) 不一致: 0.0.0.0:8080-8081 -> localhost:80-82`},
+}
+
+// dynamicCases 动态转发参数的测试用例，err 非空时为期望的完整错误信息
+var dynamicCases = []struct {
+	spec string
+	bind string
+	err  string
+}{
+	{spec: "1080", bind: "127.0.0.1:1080"},
+	{spec: "0", bind: "127.0.0.1:0"},
+	{spec: "*:1080", bind: ":1080"},
+	{spec: ":1080", bind: ":1080"},
+	{spec: "localhost:1080", bind: "localhost:1080"},
+	{spec: "0.0.0.0:1080", bind: "0.0.0.0:1080"},
+	{spec: "[::1]:1080", bind: "[::1]:1080"},
+	{spec: "[::]:1080", bind: "[::]:1080"},
+	{spec: "::1/1080", bind: "[::1]:1080"},
+	{spec: "localhost/1080", bind: "localhost:1080"},
+
+	{spec: "", err: `无效的动态转发 "": 参数为空` + bindHint},
+	{spec: "socks", err: `无效的动态转发 "socks": 无效的监听端口: "socks"` + bindHint},
+	{spec: "70000", err: `无效的动态转发 "70000": 无效的监听端口: "70000"` + bindHint},
+	{spec: "1080-1081", err: `无效的动态转发 "1080-1081": 无效的监听端口: "1080-1081"` + bindHint},
+	{spec: "::1:1080", err: `无效的动态转发 "::1:1080": 字段过多 (IPv6 地址需使用方括号，例如 [::1])` + bindHint},
+	{spec: "[::1]", err: `无效的动态转发 "[::1]": 无效的监听端口: "::1"` + bindHint},
+	{spec: "[*]:1080", err: `无效的动态转发 "[*]:1080": 无效的监听地址: "*"` + bindHint},
+	{spec: "/tmp/socks.sock:1080", err: `无效的动态转发 "/tmp/socks.sock:1080": 无效的监听地址: "/tmp/socks.sock"` + bindHint},
+}
+
+// targetCases 目标地址的测试用例，err 非空时为期望的完整错误信息
+var targetCases = []struct {
+	target string
+	user   string
+	host   string
+	port   int
+	err    string
+}{
+	{target: "example.com", host: "example.com", port: 22},
+	{target: "root@example.com", user: "root", host: "example.com", port: 22},
+	{target: "root@example.com:2222", user: "root", host: "example.com", port: 2222},
+	{target: "a@b@example.com", user: "a@b", host: "example.com", port: 22},
+	{target: "root@[::1]:2222", user: "root", host: "::1", port: 2222},
+	{target: "[::1]", host: "::1", port: 22},
+	{target: "root@::1", user: "root", host: "::1", port: 22},
+	{target: "[fe80::1%eth0]:22", host: "fe80::1%eth0", port: 22},
+	// 未加方括号时整体作为 IPv6 地址，最后一段不是端口
+	{target: "fe80::1:2222", host: "fe80::1:2222", port: 22},
+
+	{target: "@example.com", err: "用户名为空: @example.com"},
+	{target: "root@", err: "未指定主机"},
+	{target: "", err: "未指定主机"},
+	{target: "example.com:", err: "端口号为空: example.com:"},
+	{target: "[::1]:", err: "端口号为空: [::1]:"},
+	{target: "example.com:ssh", err: "无效的端口号: ssh"},
+	{target: "example.com:0", err: "无效的端口号: 0"},
+	{target: "example.com:65536", err: "无效的端口号: 65536"},
+	{target: "[::1:2222", err: "缺少与 '[' 匹配的 ']': [::1:2222"},
+	{target: "[::1]2222", err: "']' 之后只能是 :port: [::1]2222"},
+	{target: "[]:22", err: "未指定主机"},
+	{target: "[a/b]:22", err: "无效的主机地址: a/b"},
+	{target: "::1:22x", err: "无效的主机地址: ::1:22x (IPv6 地址指定端口时需使用方括号，例如 [::1]:22)"},
+	{target: "exa]mple.com", err: "无效的主机地址: exa]mple.com"},
+}
+
+func TestParseTarget(t *testing.T) {
+	for _, c := range targetCases {
+		user, host, port, err := ParseTarget(c.target)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("ParseTarget(%q) 错误 = %v, 期望 %s", c.target, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTarget(%q) 失败: %v", c.target, err)
+			continue
+		}
+		if user != c.user || host != c.host || port != c.port {
+			t.Errorf("ParseTarget(%q) = %q, %q, %d, 期望 %q, %q, %d", c.target, user, host, port, c.user, c.host, c.port)
+		}
+	}
+}
+
+func TestParseLocalForward(t *testing.T) {
+	for _, c := range localCases {
+		tunnels, err := ParseLocalForward(c.spec)
+		var binds, dests []string
+		for _, tun := range tunnels {
+			binds, dests = append(binds, tun.Bind), append(dests, tun.Target)
+		}
+		checkForward(t, c, binds, dests, err)
+	}
+}
+
+func TestParseRemoteForward(t *testing.T) {
+	for _, c := range remoteCases {
+		tunnels, err := ParseRemoteForward(c.spec)
+		var binds, dests []string
+		for _, tun := range tunnels {
+			binds, dests = append(binds, tun.Bind), append(dests, tun.Target)
+		}
+		checkForward(t, c, binds, dests, err)
+	}
+}
+
+func TestParseDynamicForward(t *testing.T) {
+	for _, c := range dynamicCases {
+		tunnel, err := ParseDynamicForward(c.spec)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("ParseDynamicForward(%q) 错误 = %v, 期望 %s", c.spec, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDynamicForward(%q) 失败: %v", c.spec, err)
+			continue
+		}
+		if tunnel.Bind != c.bind {
+			t.Errorf("ParseDynamicForward(%q) = %s, 期望 %s", c.spec, tunnel.Bind, c.bind)
+		}
+	}
+}
+
+// checkForward 比较转发参数的解析结果
+func checkForward(t *testing.T, c forwardCase, binds, dests []string, err error) {
+	t.Helper()
+	if c.err != "" {
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%q 错误 = %v, 期望 %s", c.spec, err, c.err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%q 解析失败: %v", c.spec, err)
+		return
+	}
+	if strings.Join(binds, " ") != strings.Join(c.binds, " ") || strings.Join(dests, " ") != strings.Join(c.dests, " ") {
+		t.Errorf("%q = %v -> %v, 期望 %v -> %v", c.spec, binds, dests, c.binds, c.dests)
+	}
+}
+
+// formatAddr 将解析结果转换回转发参数的字段，主机一律使用方括号
+func formatAddr(addr string) string {
+	if IsUnixSocket(addr) {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		panic(err)
+	}
+	if host == "" {
+		return "*:" + port
+	}
+	return "[" + host + "]:" + port
+}
+
+// checkError 检查错误信息以固定的前缀开头
+func checkError(t *testing.T, kind, spec string, err error) {
+	t.Helper()
+	prefix := fmt.Sprintf("无效的%s %q: ", kind, spec)
+	if !strings.HasPrefix(err.Error(), prefix) {
+		t.Fatalf("%q 的错误信息 %q 缺少前缀 %q", spec, err, prefix)
+	}
+}
+
+func FuzzParseLocalForward(f *testing.F) {
+	for _, c := range localCases {
+		f.Add(c.spec)
+	}
+	f.Fuzz(func(t *testing.T, spec string) {
+		tunnels, err := ParseLocalForward(spec)
+		if err != nil {
+			checkError(t, "本地转发", spec, err)
+			return
+		}
+		for _, tun := range tunnels {
+			again, err := ParseLocalForward(formatAddr(tun.Bind) + ":" + formatAddr(tun.Target))
+			if err != nil {
+				t.Fatalf("%q 解析结果 %s -> %s 无法再次解析: %v", spec, tun.Bind, tun.Target, err)
+			}
+			if len(again) != 1 || again[0].Bind != tun.Bind || again[0].Target != tun.Target {
+				t.Fatalf("%q 解析结果 %s -> %s 再次解析为 %+v", spec, tun.Bind, tun.Target, again)
+			}
+		}
+	})
+}
+
+func FuzzParseRemoteForward(f *testing.F) {
+	for _, c := range remoteCases {
+		f.Add(c.spec)
+	}
+	f.Fuzz(func(t *testing.T, spec string) {
+		tunnels, err := ParseRemoteForward(spec)
+		if err != nil {
+			checkError(t, "远程转发", spec, err)
+			return
+		}
+		for _, tun := range tunnels {
+			again, err := ParseRemoteForward(formatAddr(tun.Bind) + ":" + formatAddr(tun.Target))
+			if err != nil {
+				t.Fatalf("%q 解析结果 %s -> %s 无法再次解析: %v", spec, tun.Bind, tun.Target, err)
+			}
+			if len(again) != 1 || again[0].Bind != tun.Bind || again[0].Target != tun.Target {
+				t.Fatalf("%q 解析结果 %s -> %s 再次解析为 %+v", spec, tun.Bind, tun.Target, again)
+			}
+		}
+	})
+}
+
+func FuzzParseDynamicForward(f *testing.F) {
+	for _, c := range dynamicCases {
+		f.Add(c.spec)
+	}
+	f.Fuzz(func(t *testing.T, spec string) {
+		tunnel, err := ParseDynamicForward(spec)
+		if err != nil {
+			checkError(t, "动态转发", spec, err)
+			if !strings.HasSuffix(err.Error(), bindHint) {
+				t.Fatalf("%q 的错误信息 %q 缺少格式说明", spec, err)
+			}
+			return
+		}
+		again, err := ParseDynamicForward(formatAddr(tunnel.Bind))
+		if err != nil {
+			t.Fatalf("%q 解析结果 %s 无法再次解析: %v", spec, tunnel.Bind, err)
+		}
+		if again.Bind != tunnel.Bind {
+			t.Fatalf("%q 解析结果 %s 再次解析为 %s", spec, tunnel.Bind, again.Bind)
+		}
+	})
+}
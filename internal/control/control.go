@@ -0,0 +1,101 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/tunnel"
+)
+
+// 控制命令
+const (
+	CmdCheck   = "check"   // 检查主连接状态
+	CmdForward = "forward" // 添加转发，保留到主连接退出或取消
+	CmdCancel  = "cancel"  // 取消转发
+	CmdExit    = "exit"    // 主连接退出
+	CmdAttach  = "attach"  // 添加转发，控制连接关闭时自动取消
+)
+
+// dialTimeout 连接控制套接字的超时
+const dialTimeout = 5 * time.Second
+
+// Request 控制请求，每个请求占一行 JSON
+type Request struct {
+	Command string               `json:"command"`
+	Tunnels config.TunnelsConfig `json:"tunnels"`
+}
+
+// Response 控制响应
+type Response struct {
+	Error     string               `json:"error,omitempty"`
+	PID       int                  `json:"pid,omitempty"`
+	Server    string               `json:"server,omitempty"`
+	Connected bool                 `json:"connected"`
+	Removed   int                  `json:"removed,omitempty"`
+	Groups    []tunnel.TunnelGroup `json:"groups,omitempty"`
+}
+
+// Client 控制套接字客户端
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial 连接主连接的控制套接字
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Request 发送请求并等待响应，主连接返回错误时转换为 error
+func (c *Client) Request(req Request) (*Response, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("发送控制请求失败: %w", err)
+	}
+
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("读取控制响应失败: %w", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("无效的控制响应: %w", err)
+	}
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Wait 等待主连接关闭控制连接 (主连接退出)
+func (c *Client) Wait() {
+	io.Copy(io.Discard, c.r)
+}
+
+// Close 关闭控制连接，attach 添加的转发随之取消
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Send 连接控制套接字，发送单个请求后关闭
+func Send(path string, req Request) (*Response, error) {
+	c, err := Dial(path)
+	if err != nil {
+		return nil, fmt.Errorf("连接控制套接字失败: %w", err)
+	}
+	defer c.Close()
+	return c.Request(req)
+}
@@ -0,0 +1,210 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+	"autossh/internal/tunnel"
+)
+
+// maxRequestSize 单个控制请求的最大长度
+const maxRequestSize = 1 << 20
+
+// Server 主连接的控制套接字服务
+type Server struct {
+	path     string
+	client   *ssh.Client
+	mgr      *tunnel.Manager
+	exit     chan struct{}
+	exitOnce sync.Once
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer 创建控制套接字服务
+func NewServer(path string, client *ssh.Client, mgr *tunnel.Manager) *Server {
+	return &Server{
+		path:   path,
+		client: client,
+		mgr:    mgr,
+		exit:   make(chan struct{}),
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen 检查是否已有主连接，没有时清理残留的套接字文件并开始监听
+func (s *Server) Listen() error {
+	if Alive(s.path) {
+		return fmt.Errorf("控制套接字已被其他主连接使用: %s", s.path)
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("删除残留的控制套接字失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+
+	// 只允许当前用户连接，设置权限后才出现在 s.path
+	listener, err := tunnel.ListenUnix(s.path, 0o600, "")
+	if err != nil {
+		return fmt.Errorf("监听控制套接字失败: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	slog.Info("控制套接字已监听", "path", s.path)
+	s.wg.Add(1)
+	go s.serve(listener)
+	return nil
+}
+
+// Exit 返回收到 exit 命令的通知
+func (s *Server) Exit() <-chan struct{} {
+	return s.exit
+}
+
+// Stop 停止监听并关闭所有控制连接
+func (s *Server) Stop() {
+	s.mu.Lock()
+	if s.listener != nil {
+		// 关闭时删除套接字文件
+		s.listener.Close()
+		s.listener = nil
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// serve 接受控制连接
+func (s *Server) serve(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle 处理一个控制连接上的请求
+func (s *Server) handle(conn net.Conn) {
+	// attach 添加的转发在控制连接关闭时取消
+	var attached config.TunnelsConfig
+	defer func() {
+		if attached.Count() > 0 {
+			n := s.mgr.Remove(attached)
+			slog.Info("控制连接已关闭，取消附加的转发", "count", n)
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestSize)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			enc.Encode(Response{Error: fmt.Sprintf("无效的控制请求: %v", err)})
+			return
+		}
+
+		resp := s.execute(req)
+		if req.Command == CmdAttach && resp.Error == "" {
+			attached.Local = append(attached.Local, req.Tunnels.Local...)
+			attached.Remote = append(attached.Remote, req.Tunnels.Remote...)
+			attached.Dynamic = append(attached.Dynamic, req.Tunnels.Dynamic...)
+			attached.RemoteDynamic = append(attached.RemoteDynamic, req.Tunnels.RemoteDynamic...)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		if req.Command == CmdExit {
+			s.exitOnce.Do(func() { close(s.exit) })
+		}
+	}
+}
+
+// execute 执行控制命令
+func (s *Server) execute(req Request) Response {
+	resp := Response{
+		PID:       os.Getpid(),
+		Server:    s.client.Config().Address(),
+		Connected: s.client.GetConn() != nil && !s.client.IsClosed(),
+	}
+
+	switch req.Command {
+	case CmdCheck:
+		resp.Groups = s.mgr.Groups()
+
+	case CmdForward, CmdAttach:
+		if req.Tunnels.Count() == 0 {
+			resp.Error = "未指定转发"
+			break
+		}
+		if err := s.mgr.Add(req.Tunnels); err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		slog.Info("控制命令: 添加转发", "command", req.Command, "count", req.Tunnels.Count())
+
+	case CmdCancel:
+		if req.Tunnels.Count() == 0 {
+			resp.Error = "未指定转发"
+			break
+		}
+		resp.Removed = s.mgr.Remove(req.Tunnels)
+		if resp.Removed == 0 {
+			resp.Error = "未找到匹配的转发"
+			break
+		}
+		slog.Info("控制命令: 取消转发", "count", resp.Removed)
+
+	case CmdExit:
+		slog.Info("控制命令: 退出")
+
+	default:
+		resp.Error = fmt.Sprintf("未知的控制命令: %s", req.Command)
+	}
+	return resp
+}
+
+// Alive 检查控制套接字上是否有正在运行的主连接
+func Alive(path string) bool {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,132 @@
+package dnsproxy
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	// 否定应答 (NXDOMAIN 或无记录) 的缓存时间
+	negativeTTL = 30 * time.Second
+
+	// 缓存时间上限
+	maxCacheTTL = time.Hour
+)
+
+// cache DNS 应答缓存 (LRU)
+type cache struct {
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// cacheEntry 缓存条目
+type cacheEntry struct {
+	key        string
+	raw        []byte
+	ttlOffsets []int
+	stored     time.Time
+	expires    time.Time
+}
+
+// newCache 创建缓存，size <= 0 时返回 nil (不缓存)
+func newCache(size int) *cache {
+	if size <= 0 {
+		return nil
+	}
+	return &cache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get 查询缓存，返回 TTL 已按经过时间递减的应答副本
+func (c *cache) get(key string, queryID uint16) []byte {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+
+	now := time.Now()
+	if now.After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+
+	resp := make([]byte, len(entry.raw))
+	copy(resp, entry.raw)
+	setID(resp, queryID)
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	for _, off := range entry.ttlOffsets {
+		ttl := binary.BigEndian.Uint32(resp[off:])
+		if ttl > elapsed {
+			ttl -= elapsed
+		} else {
+			ttl = 0
+		}
+		binary.BigEndian.PutUint32(resp[off:], ttl)
+	}
+
+	return resp
+}
+
+// put 缓存应答，只缓存成功或 NXDOMAIN 且未截断的应答
+func (c *cache) put(key string, resp *message) {
+	if c == nil || resp.truncated {
+		return
+	}
+	if resp.rcode != rcodeSuccess && resp.rcode != rcodeNXDomain {
+		return
+	}
+
+	ttl := negativeTTL
+	if resp.minTTL >= 0 && resp.rcode == rcodeSuccess {
+		ttl = time.Duration(resp.minTTL) * time.Second
+	}
+	if ttl > maxCacheTTL {
+		ttl = maxCacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{
+		key:        key,
+		raw:        append([]byte(nil), resp.raw...),
+		ttlOffsets: resp.ttlOffsets,
+		stored:     now,
+		expires:    now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,215 @@
+package dnsproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// 报文头长度
+	headerLen = 12
+
+	// 不支持 EDNS 时的 UDP 报文最大长度
+	maxUDPSize = 512
+
+	// OPT 伪记录类型 (EDNS)
+	typeOPT = 41
+
+	// 响应码
+	rcodeSuccess  = 0
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeRefused  = 5
+
+	// 头部标志位
+	flagQR = 1 << 15
+	flagTC = 1 << 9
+)
+
+var errMalformed = errors.New("DNS报文格式无效")
+
+// question DNS 查询问题
+type question struct {
+	name   string // 小写、不含末尾的点
+	qtype  uint16
+	qclass uint16
+}
+
+// key 返回缓存键
+func (q question) key() string {
+	return fmt.Sprintf("%s/%d/%d", q.name, q.qtype, q.qclass)
+}
+
+// message 解析后的 DNS 报文 (只解析转发所需的字段)
+type message struct {
+	raw        []byte
+	question   question
+	rcode      int
+	truncated  bool
+	udpSize    int   // 查询中 EDNS 声明的 UDP 报文大小
+	minTTL     int64 // 应答和授权记录中的最小 TTL，无记录时为 -1
+	ttlOffsets []int // 所有记录 TTL 字段的偏移 (不含 OPT)
+}
+
+// parseMessage 解析 DNS 报文，只支持单个问题
+func parseMessage(raw []byte) (*message, error) {
+	if len(raw) < headerLen {
+		return nil, errMalformed
+	}
+
+	flags := binary.BigEndian.Uint16(raw[2:])
+	qdCount := binary.BigEndian.Uint16(raw[4:])
+	anCount := binary.BigEndian.Uint16(raw[6:])
+	nsCount := binary.BigEndian.Uint16(raw[8:])
+	arCount := binary.BigEndian.Uint16(raw[10:])
+
+	if qdCount != 1 {
+		return nil, errMalformed
+	}
+
+	m := &message{
+		raw:       raw,
+		rcode:     int(flags & 0x0F),
+		truncated: flags&flagTC != 0,
+		udpSize:   maxUDPSize,
+		minTTL:    -1,
+	}
+
+	name, off, err := readName(raw, headerLen)
+	if err != nil {
+		return nil, err
+	}
+	if off+4 > len(raw) {
+		return nil, errMalformed
+	}
+	m.question = question{
+		name:   strings.ToLower(name),
+		qtype:  binary.BigEndian.Uint16(raw[off:]),
+		qclass: binary.BigEndian.Uint16(raw[off+2:]),
+	}
+	off += 4
+
+	// 遍历应答、授权和附加记录
+	sections := []uint16{anCount, nsCount, arCount}
+	for i, count := range sections {
+		for j := 0; j < int(count); j++ {
+			if _, off, err = readName(raw, off); err != nil {
+				return nil, err
+			}
+			if off+10 > len(raw) {
+				return nil, errMalformed
+			}
+			rrType := binary.BigEndian.Uint16(raw[off:])
+			rrClass := binary.BigEndian.Uint16(raw[off+2:])
+			ttlOff := off + 4
+			rdLen := int(binary.BigEndian.Uint16(raw[off+8:]))
+			off += 10 + rdLen
+			if off > len(raw) {
+				return nil, errMalformed
+			}
+
+			if rrType == typeOPT {
+				if int(rrClass) > maxUDPSize {
+					m.udpSize = int(rrClass)
+				}
+				continue
+			}
+
+			m.ttlOffsets = append(m.ttlOffsets, ttlOff)
+			if i < 2 {
+				ttl := int64(binary.BigEndian.Uint32(raw[ttlOff:]))
+				if m.minTTL < 0 || ttl < m.minTTL {
+					m.minTTL = ttl
+				}
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// readName 读取 (可能压缩的) 域名，返回域名和其后的偏移
+func readName(raw []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+
+	for jumps := 0; ; {
+		if off >= len(raw) {
+			return "", 0, errMalformed
+		}
+		length := int(raw[off])
+
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+
+		case length&0xC0 == 0xC0:
+			// 压缩指针
+			if off+1 >= len(raw) || jumps > 16 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(raw[off:]) & 0x3FFF)
+			jumps++
+
+		default:
+			if off+1+length > len(raw) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(raw[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// id 返回报文 ID
+func id(raw []byte) uint16 {
+	return binary.BigEndian.Uint16(raw)
+}
+
+// setID 设置报文 ID
+func setID(raw []byte, id uint16) {
+	binary.BigEndian.PutUint16(raw, id)
+}
+
+// errorResponse 根据查询构造只包含问题的错误响应
+func errorResponse(query *message, rcode int) []byte {
+	resp := truncatedResponse(query.raw, query)
+	flags := binary.BigEndian.Uint16(resp[2:])
+	flags = flags&^(0x0F|flagTC) | uint16(rcode)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	return resp
+}
+
+// truncatedResponse 返回只包含头部和问题的响应，并设置 TC 标志
+// 用于响应超过客户端 UDP 报文大小的情况
+func truncatedResponse(raw []byte, query *message) []byte {
+	// 问题部分以查询报文为准
+	qEnd := headerLen
+	for qEnd < len(query.raw) && query.raw[qEnd] != 0 {
+		qEnd += 1 + int(query.raw[qEnd])
+	}
+	qEnd += 1 + 4
+	if qEnd > len(query.raw) {
+		qEnd = len(query.raw)
+	}
+
+	resp := make([]byte, qEnd)
+	copy(resp, query.raw[:qEnd])
+	copy(resp[:4], raw[:4])
+
+	flags := binary.BigEndian.Uint16(resp[2:]) | flagQR | flagTC
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], 0)
+	binary.BigEndian.PutUint16(resp[8:], 0)
+	binary.BigEndian.PutUint16(resp[10:], 0)
+	return resp
+}
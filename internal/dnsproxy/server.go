@@ -0,0 +1,304 @@
+// Package dnsproxy 实现本地 DNS 转发
+//
+// 本地监听 UDP 和 TCP，将指定域名的查询通过SSH隧道以 TCP DNS 转发到
+// 远程网络中的 DNS 服务器，其余查询转发到本地的 DNS 服务器 (分离解析)。
+package dnsproxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+)
+
+const (
+	// 默认缓存条目数
+	defaultCacheSize = 1024
+
+	// 默认查询超时
+	defaultTimeout = 5 * time.Second
+)
+
+// Server 本地 DNS 转发服务
+// 独立于SSH连接运行，连接断开期间通过隧道的查询返回 SERVFAIL
+type Server struct {
+	client      *ssh.Client
+	cfg         config.DNSConfig
+	resolver    string
+	fallback    string
+	zones       []string
+	timeout     time.Duration
+	cache       *cache
+	udpConn     *net.UDPConn
+	tcpListener net.Listener
+	wg          sync.WaitGroup
+}
+
+// NewServer 创建 DNS 转发服务
+func NewServer(client *ssh.Client, cfg config.DNSConfig) *Server {
+	s := &Server{
+		client:   client,
+		cfg:      cfg,
+		resolver: withDefaultPort(cfg.Resolver),
+		timeout:  cfg.Timeout,
+	}
+	if cfg.Fallback != "" {
+		s.fallback = withDefaultPort(cfg.Fallback)
+	}
+	for _, zone := range cfg.Zones {
+		s.zones = append(s.zones, strings.ToLower(strings.Trim(zone, ".")))
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+
+	cacheSize := cfg.CacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultCacheSize
+	}
+	s.cache = newCache(cacheSize)
+
+	return s
+}
+
+// Start 启动服务
+func (s *Server) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", s.cfg.Bind)
+	if err != nil {
+		return fmt.Errorf("无效的DNS监听地址 %s: %w", s.cfg.Bind, err)
+	}
+	s.udpConn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("DNS UDP监听失败 %s: %w", s.cfg.Bind, err)
+	}
+
+	s.tcpListener, err = net.Listen("tcp", s.cfg.Bind)
+	if err != nil {
+		s.udpConn.Close()
+		return fmt.Errorf("DNS TCP监听失败 %s: %w", s.cfg.Bind, err)
+	}
+
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+
+	slog.Info("DNS转发已启动", "bind", s.cfg.Bind, "resolver", s.resolver, "zones", s.cfg.Zones, "fallback", s.fallback)
+	return nil
+}
+
+// Stop 停止服务
+func (s *Server) Stop() {
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+	s.wg.Wait()
+}
+
+// serveUDP 处理 UDP 查询
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := s.udpConn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.handle(query, false); resp != nil {
+				s.udpConn.WriteToUDPAddrPort(resp, from)
+			}
+		}()
+	}
+}
+
+// serveTCP 处理 TCP 查询
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleTCP(conn)
+	}
+}
+
+// handleTCP 处理一个 TCP 连接上的查询 (可包含多个查询)
+func (s *Server) handleTCP(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		resp := s.handle(query, true)
+		if resp == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle 处理一个查询，无法解析的查询返回 nil
+func (s *Server) handle(raw []byte, tcp bool) []byte {
+	query, err := parseMessage(raw)
+	if err != nil {
+		slog.Debug("丢弃无效的DNS查询", "error", err)
+		return nil
+	}
+
+	resp := s.resolve(query, tcp)
+	if !tcp && len(resp) > query.udpSize {
+		resp = truncatedResponse(resp, query)
+	}
+	return resp
+}
+
+// resolve 解析查询，优先使用缓存
+func (s *Server) resolve(query *message, tcp bool) []byte {
+	key := query.question.key()
+	if resp := s.cache.get(key, id(query.raw)); resp != nil {
+		slog.Debug("DNS缓存命中", "name", query.question.name, "type", query.question.qtype)
+		return resp
+	}
+
+	var (
+		raw []byte
+		err error
+	)
+	switch {
+	case s.inZones(query.question.name):
+		raw, err = s.exchangeTunnel(query.raw)
+	case s.fallback != "":
+		raw, err = s.exchangeFallback(query.raw, tcp)
+	default:
+		return errorResponse(query, rcodeRefused)
+	}
+	if err != nil {
+		slog.Debug("DNS查询失败", "name", query.question.name, "error", err)
+		return errorResponse(query, rcodeServFail)
+	}
+
+	resp, err := parseMessage(raw)
+	if err != nil || id(raw) != id(query.raw) {
+		slog.Debug("DNS应答无效", "name", query.question.name, "error", err)
+		return errorResponse(query, rcodeServFail)
+	}
+
+	s.cache.put(key, resp)
+	return raw
+}
+
+// inZones 判断域名是否应通过隧道解析
+func (s *Server) inZones(name string) bool {
+	if len(s.zones) == 0 {
+		return true
+	}
+	for _, zone := range s.zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchangeTunnel 通过SSH隧道以 TCP DNS 查询远程 DNS 服务器
+func (s *Server) exchangeTunnel(query []byte) ([]byte, error) {
+	conn, err := s.client.Dial("tcp", s.resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// SSH 通道不支持 deadline，超时后关闭连接
+	timer := time.AfterFunc(s.timeout, func() { conn.Close() })
+	defer timer.Stop()
+
+	return exchangeTCP(conn, query)
+}
+
+// exchangeFallback 查询本地 DNS 服务器
+func (s *Server) exchangeFallback(query []byte, tcp bool) ([]byte, error) {
+	network := "udp"
+	if tcp {
+		network = "tcp"
+	}
+
+	conn, err := net.DialTimeout(network, s.fallback, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if tcp {
+		return exchangeTCP(conn, query)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// exchangeTCP 在 TCP 连接上发送查询并读取应答
+func exchangeTCP(conn net.Conn, query []byte) ([]byte, error) {
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+// readTCPMessage 读取带2字节长度前缀的 DNS 报文
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage 写入带2字节长度前缀的 DNS 报文
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// withDefaultPort 地址未指定端口时使用 53
+func withDefaultPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+}
@@ -1,11 +1,15 @@
 package monitor
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"sync"
 	"time"
 
 	"autossh/internal/config"
+	"autossh/internal/session"
 	"autossh/internal/ssh"
 	"autossh/internal/tunnel"
 )
@@ -14,110 +18,273 @@ import (
 type Monitor struct {
 	client    *ssh.Client
 	tunnelMgr *tunnel.Manager
+	session   *session.Runner // 未配置远程命令时为 nil
 	cfg       *config.Config
 	stopCh    chan struct{}
 	mu        sync.Mutex
-	running   bool
+	stopped   bool
+	drain     context.Context // 退出时等待现有连接结束的上下文，取消时立即关闭
+	status    StatusFunc
+	watchdog  func()        // 看门狗通知，未启用时为 nil
+	beat      time.Duration // 看门狗通知间隔
 }
 
+// keepAliveInterval 保活请求间隔
+const keepAliveInterval = 30 * time.Second
+
+// StatusFunc 接收监控器的状态描述，ready 为 true 表示连接和隧道已就绪
+type StatusFunc func(status string, ready bool)
+
 // NewMonitor 创建监控器
 func NewMonitor(client *ssh.Client, tunnelMgr *tunnel.Manager, cfg *config.Config) *Monitor {
-	return &Monitor{
+	m := &Monitor{
 		client:    client,
 		tunnelMgr: tunnelMgr,
 		cfg:       cfg,
 		stopCh:    make(chan struct{}),
 	}
+	if cfg.Session.Command != "" {
+		m.session = session.NewRunner(client, cfg.Session)
+	}
+	return m
 }
 
-// Start 启动监控器
-func (m *Monitor) Start() error {
-	m.mu.Lock()
-	m.running = true
-	m.mu.Unlock()
+// ErrStopped 监控器在连接成功前被停止
+var ErrStopped = errors.New("监控器已停止")
 
-	retryCount := 0
-	maxRetries := m.cfg.Reconnect.MaxRetries
-	interval := m.cfg.Reconnect.Interval
+// SetStatusFunc 设置状态变化时的回调，需在 Start 之前调用
+func (m *Monitor) SetStatusFunc(fn StatusFunc) {
+	m.status = fn
+}
+
+// SetWatchdog 设置看门狗通知，需在 Start 之前调用
+// 连接正常时每次保活成功后通知，重连期间每次尝试连接和退避等待时按 timeout 的一半通知，
+// 长时间断网不会错过通知，而监控器卡住时不再通知，超时后由看门狗重启服务
+func (m *Monitor) SetWatchdog(timeout time.Duration, fn func()) {
+	m.watchdog = fn
+	m.beat = timeout / 2
+}
+
+// alive 通知看门狗监控器仍在正常工作
+func (m *Monitor) alive() {
+	if m.watchdog != nil {
+		m.watchdog()
+	}
+}
+
+// sleep 等待 d，期间按看门狗间隔通知，监控器停止时返回 false
+func (m *Monitor) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var beat <-chan time.Time
+	if m.watchdog != nil {
+		ticker := time.NewTicker(m.beat)
+		defer ticker.Stop()
+		beat = ticker.C
+	}
 
 	for {
-		// 检查是否应该停止
 		select {
 		case <-m.stopCh:
-			slog.Info("监控器已停止")
-			return nil
-		default:
+			return false
+		case <-timer.C:
+			return true
+		case <-beat:
+			m.alive()
 		}
+	}
+}
 
-		// 建立连接
-		if err := m.client.Connect(); err != nil {
-			slog.Error("连接失败", "error", err)
+// setStatus 报告当前状态
+func (m *Monitor) setStatus(ready bool, format string, args ...any) {
+	if m.status != nil {
+		m.status(fmt.Sprintf(format, args...), ready)
+	}
+}
 
-			if !m.cfg.Reconnect.Enabled {
-				return err
+// Start 启动监控器
+func (m *Monitor) Start() error {
+	for {
+		// 建立连接
+		if err := m.Connect(); err != nil {
+			if errors.Is(err, ErrStopped) {
+				slog.Info("监控器已停止")
+				return nil
 			}
+			return err
+		}
 
-			retryCount++
-			if maxRetries > 0 && retryCount >= maxRetries {
-				slog.Error("达到最大重试次数", "count", retryCount)
-				return err
+		// 启动隧道
+		if err := m.tunnelMgr.Start(); err != nil {
+			slog.Error("启动隧道失败", "error", err)
+			m.client.Close()
+			continue
+		}
+
+		// 启动远程命令
+		var sessionDone <-chan error
+		if m.session != nil {
+			if err := m.session.Start(); err != nil {
+				slog.Error("启动远程命令失败", "error", err)
+				if m.cfg.Session.ExitIsFailure {
+					failed := make(chan error, 1)
+					failed <- err
+					sessionDone = failed
+				}
+			} else {
+				sessionDone = m.session.Done()
 			}
+		}
 
-			waitTime := m.calculateBackoff(retryCount, interval)
-			slog.Info("等待重连", "seconds", waitTime.Seconds(), "attempt", retryCount)
+		// 启动保活和监控，保活间隔不超过看门狗通知间隔
+		errChan := make(chan error, 1)
+		keepAlive := make(chan struct{}, 1)
+		interval := keepAliveInterval
+		if m.watchdog != nil {
+			interval = min(interval, m.beat)
+		}
+		m.client.StartKeepAlive(interval, errChan, keepAlive)
+		m.setStatus(true, "已连接 %s，%d 个隧道", m.cfg.Address(), m.tunnelMgr.TunnelCount())
+		m.alive()
 
+		// 等待连接断开、远程命令退出或停止信号
+		var lost error
+		sessionFailed := false
+	wait:
+		for {
 			select {
 			case <-m.stopCh:
+				m.setStatus(false, "正在退出")
+				m.disconnect(true)
 				return nil
-			case <-time.After(waitTime):
-				continue
+
+			case <-keepAlive:
+				m.alive()
+
+			case err := <-errChan:
+				slog.Warn("连接断开", "error", err)
+				lost = err
+				break wait
+
+			case err := <-sessionDone:
+				sessionDone = nil
+				if err == nil {
+					err = errors.New("远程命令已退出")
+				}
+				if !m.cfg.Session.ExitIsFailure {
+					slog.Warn("远程命令已退出，将在重连后重新启动", "error", err)
+					continue
+				}
+				slog.Warn("远程命令已退出，视为连接失败", "error", err)
+				lost = err
+				sessionFailed = true
+				break wait
 			}
 		}
 
-		// 连接成功，重置重试计数
-		retryCount = 0
+		m.setStatus(false, "连接断开: %v", lost)
+		m.disconnect(false)
+		if !m.cfg.Reconnect.Enabled {
+			return lost
+		}
 
-		// 启动隧道
-		if err := m.tunnelMgr.Start(); err != nil {
-			slog.Error("启动隧道失败", "error", err)
-			m.client.Close()
-			continue
+		slog.Info("准备重连...")
+		if sessionFailed {
+			// 命令持续快速退出时避免频繁重连
+			if !m.sleep(m.cfg.Reconnect.Interval) {
+				return nil
+			}
 		}
+	}
+}
 
-		// 启动保活和监控
-		errChan := make(chan error, 1)
-		m.client.StartKeepAlive(30*time.Second, errChan)
+// disconnect 停止远程命令和隧道并关闭连接
+// graceful 为 true 时 (主动停止) 先等待现有连接结束再关闭SSH连接，
+// 否则 (连接断开) 先关闭SSH连接，使阻塞在失效连接上的转发立即结束
+func (m *Monitor) disconnect(graceful bool) {
+	if !graceful {
+		m.client.Close()
+	}
+	if m.session != nil {
+		m.session.Stop()
+	}
+	if graceful {
+		m.tunnelMgr.Shutdown(m.drainContext())
+	} else {
+		m.tunnelMgr.Stop()
+	}
+	m.client.Close()
+}
 
-		// 等待连接断开或停止信号
+// Connect 建立SSH连接，失败时按重连配置退避重试
+// 直到连接成功、达到最大重试次数或监控器停止 (返回 ErrStopped)
+func (m *Monitor) Connect() error {
+	retryCount := 0
+	maxRetries := m.cfg.Reconnect.MaxRetries
+	interval := m.cfg.Reconnect.Interval
+
+	for {
+		// 检查是否应该停止
 		select {
 		case <-m.stopCh:
-			m.tunnelMgr.Stop()
-			m.client.Close()
+			return ErrStopped
+		default:
+		}
+
+		m.setStatus(false, "正在连接 %s", m.cfg.Address())
+		m.alive()
+		err := m.client.Connect()
+		if err == nil {
 			return nil
+		}
+		slog.Error("连接失败", "error", err)
 
-		case err := <-errChan:
-			slog.Warn("连接断开", "error", err)
-			m.tunnelMgr.Stop()
-			m.client.Close()
+		if !m.cfg.Reconnect.Enabled {
+			return err
+		}
 
-			if !m.cfg.Reconnect.Enabled {
-				return err
-			}
+		retryCount++
+		if maxRetries > 0 && retryCount >= maxRetries {
+			slog.Error("达到最大重试次数", "count", retryCount)
+			return err
+		}
+
+		waitTime := m.calculateBackoff(retryCount, interval)
+		slog.Info("等待重连", "seconds", waitTime.Seconds(), "attempt", retryCount)
+		m.setStatus(false, "连接失败，%s 后重试 (第 %d 次): %v", waitTime, retryCount, err)
 
-			slog.Info("准备重连...")
+		if !m.sleep(waitTime) {
+			return ErrStopped
 		}
 	}
 }
 
 // Stop 停止监控器
 func (m *Monitor) Stop() {
+	m.Shutdown(context.Background())
+}
+
+// Shutdown 停止监控器，现有连接最多等待 shutdown.grace_period，ctx 取消时立即关闭
+func (m *Monitor) Shutdown(ctx context.Context) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.running {
+	if !m.stopped {
+		m.drain = ctx
 		close(m.stopCh)
-		m.running = false
+		m.stopped = true
+	}
+}
+
+// drainContext 返回退出时等待现有连接结束的上下文
+func (m *Monitor) drainContext() context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.drain == nil {
+		return context.Background()
 	}
+	return m.drain
 }
 
 // calculateBackoff 计算退避时间（指数退避，最大60秒）
@@ -0,0 +1,187 @@
+// Package pac 根据动态转发及其路由规则生成代理自动配置 (PAC) 文件
+package pac
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"autossh/internal/config"
+)
+
+// pacHelpers PAC 文件中使用的辅助函数
+const pacHelpers = `
+function isIPv4(host) {
+  return /^\d+\.\d+\.\d+\.\d+$/.test(host);
+}
+
+function urlPort(url) {
+  var m = url.match(/^([a-z][a-z0-9+.-]*):\/\/(?:[^\/@]*@)?(\[[^\]]*\]|[^\/:?#]*)(?::(\d+))?/i);
+  if (!m) return 0;
+  if (m[3]) return parseInt(m[3], 10);
+  var scheme = m[1].toLowerCase();
+  if (scheme == "https" || scheme == "wss") return 443;
+  if (scheme == "ftp") return 21;
+  return 80;
+}
+`
+
+// Generate 根据动态转发配置生成 PAC 文件内容
+// 只有 direct 规则生成 DIRECT，其余目标 (tunnel/upstream/reject) 都交给代理，
+// 由代理按同一套规则处理；无法在 PAC 中精确表达的条件也交给代理判断
+func Generate(tunnel *config.DynamicTunnel, proxyHost string) (string, error) {
+	proxyAddr, err := proxyAddress(tunnel.Bind, proxyHost)
+	if err != nil {
+		return "", err
+	}
+
+	routing := tunnel.Routing
+	rules := routing.Rules
+	if routing.RulesFile != "" {
+		fileRules, err := config.LoadRouteRules(routing.RulesFile)
+		if err != nil {
+			return "", err
+		}
+		rules = append(rules[:len(rules):len(rules)], fileRules...)
+	}
+
+	proxy := fmt.Sprintf("SOCKS5 %s; SOCKS %s; PROXY %s", proxyAddr, proxyAddr, proxyAddr)
+	if tunnel.TLS.Enabled() {
+		// 浏览器只支持通过 TLS 连接 HTTP 代理
+		proxy = "HTTPS " + proxyAddr
+	}
+
+	var b strings.Builder
+	b.WriteString("// 由 autossh 生成，请勿手动修改\n")
+	fmt.Fprintf(&b, "// 动态转发: %s\n", tunnel.Bind)
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(&b, "  var proxy = %s;\n", jsString(proxy))
+	b.WriteString("  host = host.toLowerCase();\n")
+	b.WriteString("  var port = urlPort(url);\n")
+
+	for _, rule := range rules {
+		direct := rule.Action == config.RouteDirect
+		result := "proxy"
+		if direct {
+			result = `"DIRECT"`
+		}
+		fmt.Fprintf(&b, "  if (%s) return %s;\n", ruleCondition(rule, direct), result)
+	}
+
+	if routing.Default == config.RouteDirect {
+		b.WriteString("  return \"DIRECT\";\n")
+	} else {
+		b.WriteString("  return proxy;\n")
+	}
+	b.WriteString("}\n")
+	b.WriteString(pacHelpers)
+
+	return b.String(), nil
+}
+
+// proxyAddress 返回 PAC 中使用的代理地址
+// 监听在任意地址时默认使用 127.0.0.1
+func proxyAddress(bind, proxyHost string) (string, error) {
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", fmt.Errorf("无效的动态转发地址 %s: %w", bind, err)
+	}
+
+	if proxyHost != "" {
+		host = proxyHost
+	} else if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ruleCondition 将路由规则转换为 JavaScript 条件表达式
+// direct 规则中无法精确表达的条件取 false，其余规则取宽松匹配，保证结果交给代理时仍然正确
+func ruleCondition(rule config.RouteRule, direct bool) string {
+	var groups []string
+
+	if len(rule.Domains) > 0 {
+		var conds []string
+		for _, d := range rule.Domains {
+			d = strings.ToLower(strings.Trim(d, "."))
+			conds = append(conds, fmt.Sprintf("host == %s || dnsDomainIs(host, %s)", jsString(d), jsString("."+d)))
+		}
+		groups = append(groups, or(conds))
+	}
+
+	if len(rule.Globs) > 0 {
+		var conds []string
+		for _, g := range rule.Globs {
+			conds = append(conds, fmt.Sprintf("shExpMatch(host, %s)", jsString(strings.ToLower(g))))
+		}
+		groups = append(groups, or(conds))
+	}
+
+	if len(rule.CIDRs) > 0 {
+		var conds []string
+		for _, cidr := range rule.CIDRs {
+			prefix, err := config.ParsePrefix(cidr)
+			if err != nil {
+				continue
+			}
+			prefix = prefix.Masked()
+			if prefix.Addr().Is4() {
+				conds = append(conds, fmt.Sprintf("isIPv4(host) && isInNet(host, %s, %s)",
+					jsString(prefix.Addr().String()), jsString(ipv4Mask(prefix))))
+			} else if !direct {
+				// PAC 无法判断 IPv6 网段，交给代理
+				conds = append(conds, `host.indexOf(":") >= 0`)
+			}
+		}
+		if len(conds) == 0 {
+			conds = append(conds, "false")
+		}
+		groups = append(groups, or(conds))
+	}
+
+	if len(rule.Ports) > 0 {
+		var conds []string
+		for _, ports := range rule.Ports {
+			low, high, err := config.ParsePortRange(ports)
+			if err != nil {
+				continue
+			}
+			if low == high {
+				conds = append(conds, fmt.Sprintf("port == %d", low))
+			} else {
+				conds = append(conds, fmt.Sprintf("port >= %d && port <= %d", low, high))
+			}
+		}
+		groups = append(groups, or(conds))
+	}
+
+	if len(groups) == 0 {
+		return "true"
+	}
+	return strings.Join(groups, " && ")
+}
+
+// or 使用 || 连接条件
+func or(conds []string) string {
+	if len(conds) == 1 {
+		return "(" + conds[0] + ")"
+	}
+	wrapped := make([]string, len(conds))
+	for i, c := range conds {
+		wrapped[i] = "(" + c + ")"
+	}
+	return "(" + strings.Join(wrapped, " || ") + ")"
+}
+
+// ipv4Mask 返回 IPv4 网段的点分十进制掩码
+func ipv4Mask(prefix netip.Prefix) string {
+	return net.IP(net.CIDRMask(prefix.Bits(), 32)).String()
+}
+
+// jsString 返回 JavaScript 字符串字面量
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
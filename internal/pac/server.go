@@ -0,0 +1,83 @@
+package pac
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"autossh/internal/config"
+)
+
+// Server PAC 文件 HTTP 服务
+// 独立于SSH连接运行，每次请求时重新生成，以反映规则文件的修改
+type Server struct {
+	cfg    *config.Config
+	server *http.Server
+}
+
+// NewServer 创建 PAC 文件服务
+func NewServer(cfg *config.Config) *Server {
+	return &Server{
+		cfg: cfg,
+	}
+}
+
+// Start 启动服务
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.cfg.PAC.Bind)
+	if err != nil {
+		return fmt.Errorf("PAC 服务监听失败 %s: %w", s.cfg.PAC.Bind, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/proxy.pac", s.handlePAC)
+	mux.HandleFunc("/wpad.dat", s.handlePAC)
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("PAC 服务异常退出", "error", err)
+		}
+	}()
+
+	slog.Info("PAC 服务已启动", "url", fmt.Sprintf("http://%s/proxy.pac", listener.Addr()))
+	return nil
+}
+
+// Stop 停止服务
+func (s *Server) Stop() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
+
+// handlePAC 返回生成的 PAC 文件
+func (s *Server) handlePAC(w http.ResponseWriter, r *http.Request) {
+	tunnel, err := s.cfg.PACTunnel()
+	if err != nil {
+		slog.Warn("生成 PAC 文件失败", "error", err)
+		http.Error(w, "生成 PAC 文件失败", http.StatusInternalServerError)
+		return
+	}
+
+	content, err := Generate(tunnel, s.cfg.PAC.ProxyHost)
+	if err != nil {
+		slog.Warn("生成 PAC 文件失败", "error", err)
+		http.Error(w, "生成 PAC 文件失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, content)
+}
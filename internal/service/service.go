@@ -0,0 +1,232 @@
+// Package service 根据当前配置生成服务管理器的服务文件
+// 支持 systemd (服务单元和套接字激活单元)、OpenRC 初始化脚本和 launchd 属性列表
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"autossh/internal/config"
+)
+
+// 服务文件格式
+const (
+	FormatSystemd = "systemd"
+	FormatOpenRC  = "openrc"
+	FormatLaunchd = "launchd"
+)
+
+// stopMargin 退出宽限期之外等待进程结束的时间 (强制关闭连接、保存状态)
+const stopMargin = 10 * time.Second
+
+// Options 生成服务文件的参数
+type Options struct {
+	Name       string        // 服务名称 (launchd 中作为 Label)
+	Binary     string        // autossh 可执行文件的绝对路径
+	ConfigFile string        // 配置文件的绝对路径
+	User       string        // 运行服务的用户 (为空则以 root 运行)
+	EnvFile    string        // 环境变量文件的绝对路径，保存 AUTOSSH_PASSWORD 等敏感信息
+	Watchdog   time.Duration // systemd 看门狗超时 (0 = 不启用)
+	Socket     bool          // 生成 systemd 套接字激活单元，由 systemd 监听本地转发和动态转发的地址
+}
+
+// File 生成的服务文件
+type File struct {
+	Name    string      // 文件名
+	Mode    os.FileMode // 文件权限
+	Content []byte
+	Secret  bool // 环境变量文件: 已存在时不覆盖
+}
+
+// unit 模板使用的数据
+type unit struct {
+	Options
+	Description  string
+	RestartSec   int
+	StopTimeout  int
+	Listen       []string // 套接字激活的监听地址
+	Capabilities []string // 需要保留的权限
+	WritePaths   []string // 需要写入的目录
+	PrivateTmp   bool
+	Env          []string // 环境变量文件中的变量
+}
+
+// Render 生成指定格式的服务文件
+func Render(format string, cfg *config.Config, opts Options) ([]File, error) {
+	if opts.Name == "" {
+		return nil, fmt.Errorf("未指定服务名称")
+	}
+	if !filepath.IsAbs(opts.Binary) || !filepath.IsAbs(opts.ConfigFile) {
+		return nil, fmt.Errorf("可执行文件和配置文件需要使用绝对路径")
+	}
+
+	u, err := newUnit(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	switch format {
+	case FormatSystemd:
+		files = append(files, File{Name: opts.Name + ".service", Mode: 0644, Content: u.render(systemdService)})
+		if opts.Socket {
+			if len(u.Listen) == 0 {
+				return nil, fmt.Errorf("没有可由 systemd 监听的本地转发或动态转发")
+			}
+			files = append(files, File{Name: opts.Name + ".socket", Mode: 0644, Content: u.render(systemdSocket)})
+		}
+	case FormatOpenRC:
+		files = append(files, File{Name: opts.Name, Mode: 0755, Content: u.render(openrcScript)})
+	case FormatLaunchd:
+		files = append(files, File{Name: opts.Name + ".plist", Mode: 0644, Content: u.render(launchdPlist)})
+	default:
+		return nil, fmt.Errorf("未知的服务文件格式: %s (期望: systemd、openrc 或 launchd)", format)
+	}
+
+	if opts.EnvFile != "" {
+		files = append(files, File{Name: filepath.Base(opts.EnvFile), Mode: 0600, Content: u.render(envFile), Secret: true})
+	}
+	return files, nil
+}
+
+// newUnit 根据配置计算服务需要的权限、可写目录和监听地址
+func newUnit(cfg *config.Config, opts Options) (*unit, error) {
+	u := &unit{
+		Options:     opts,
+		Description: fmt.Sprintf("autossh tunnels to %s@%s:%d", cfg.Server.User, cfg.Server.Host, cfg.Server.Port),
+		RestartSec:  seconds(cfg.Reconnect.Interval),
+		StopTimeout: seconds(cfg.Shutdown.GracePeriod + stopMargin),
+		PrivateTmp:  true,
+		Env:         []string{config.EnvPassword, config.EnvPassphrase},
+	}
+	if u.RestartSec == 0 {
+		u.RestartSec = 1
+	}
+
+	// 由服务自行监听的地址: 1024 以下的端口需要 CAP_NET_BIND_SERVICE
+	var binds []string
+	for _, t := range cfg.Tunnels.Local {
+		binds = append(binds, t.Bind)
+		if t.SocketOwner != "" && !opts.Socket {
+			u.addCapability("CAP_CHOWN")
+		}
+	}
+	for _, t := range cfg.Tunnels.Dynamic {
+		binds = append(binds, t.Bind)
+	}
+	for _, bind := range binds {
+		if opts.Socket {
+			listen, err := listenStream(bind)
+			if err != nil {
+				return nil, err
+			}
+			u.Listen = append(u.Listen, listen)
+			continue
+		}
+		if privileged(bind) {
+			u.addCapability("CAP_NET_BIND_SERVICE")
+		}
+		if config.IsUnixSocket(bind) {
+			u.addWritePath(filepath.Dir(bind))
+		}
+	}
+	for _, bind := range []string{cfg.PAC.Bind, cfg.DNS.Bind} {
+		if bind != "" && privileged(bind) {
+			u.addCapability("CAP_NET_BIND_SERVICE")
+		}
+	}
+
+	// 运行时写入的文件
+	if cfg.StateFile != "" {
+		u.addWritePath(filepath.Dir(cfg.StateFile))
+	}
+	if cfg.Bandwidth.QuotaEnabled() && cfg.Bandwidth.QuotaFile != "" {
+		u.addWritePath(filepath.Dir(cfg.Bandwidth.QuotaFile))
+	}
+	if out := cfg.Session.Output; out != "" && out != config.SessionOutputLog && out != config.SessionOutputDiscard {
+		u.addWritePath(filepath.Dir(out))
+	}
+	if path := cfg.ControlPath(); path != "" {
+		u.addWritePath(filepath.Dir(path))
+	}
+
+	// 需要在 /tmp 中创建文件时不能使用私有的 /tmp
+	for _, dir := range u.WritePaths {
+		if dir == "/tmp" || dir == "/var/tmp" || strings.HasPrefix(dir, "/tmp/") || strings.HasPrefix(dir, "/var/tmp/") {
+			u.PrivateTmp = false
+		}
+	}
+	return u, nil
+}
+
+// addCapability 添加需要保留的权限
+func (u *unit) addCapability(c string) {
+	if !slices.Contains(u.Capabilities, c) {
+		u.Capabilities = append(u.Capabilities, c)
+	}
+}
+
+// addWritePath 添加需要写入的目录
+func (u *unit) addWritePath(dir string) {
+	if !slices.Contains(u.WritePaths, dir) {
+		u.WritePaths = append(u.WritePaths, dir)
+		slices.Sort(u.WritePaths)
+	}
+}
+
+// render 使用模板生成文件内容
+func (u *unit) render(tmpl *template.Template) []byte {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, u); err != nil {
+		// 模板在编译时固定，执行失败只可能是程序错误
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// listenStream 将隧道的监听地址转换为 systemd ListenStream= 的格式
+// systemd 不解析主机名，localhost 转换为 127.0.0.1，未指定地址时只写端口 (监听所有地址)
+func listenStream(bind string) (string, error) {
+	if config.IsUnixSocket(bind) {
+		return bind, nil
+	}
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", fmt.Errorf("无效的监听地址 %s: %w", bind, err)
+	}
+	switch {
+	case host == "":
+		return port, nil
+	case host == "localhost":
+		host = "127.0.0.1"
+	case net.ParseIP(host) == nil:
+		return "", fmt.Errorf("套接字激活不支持主机名形式的监听地址: %s", bind)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// privileged 检查 TCP 监听地址是否使用 1024 以下的端口
+func privileged(bind string) bool {
+	if config.IsUnixSocket(bind) {
+		return false
+	}
+	_, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 1024
+}
+
+// seconds 将时长向上取整为秒
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
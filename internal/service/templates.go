@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// funcs 模板函数
+var funcs = template.FuncMap{
+	"seconds": seconds,
+	"join":    strings.Join,
+	"lower":   strings.ToLower,
+	"quote":   quote,
+	"unit":    unitEscape,
+	"shquote": shquote,
+	"xml":     xmlEscape,
+}
+
+// systemdService systemd 服务单元
+// 除必要的权限和可写目录外限制文件系统、内核和系统调用的访问
+var systemdService = template.Must(template.New("service").Funcs(funcs).Parse(`# {{.Name}}.service
+# 由 autossh install-service 生成
+
+[Unit]
+Description={{unit .Description}}
+After=network-online.target{{if .Socket}} {{.Name}}.socket{{end}}
+Wants=network-online.target
+{{- if .Socket}}
+Requires={{.Name}}.socket
+{{- end}}
+# 与 autossh 一致，一直重启而不是在多次失败后放弃
+StartLimitIntervalSec=0
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{quote .Binary}} -c {{quote .ConfigFile}}
+ExecReload=/bin/kill -HUP $MAINPID
+{{- if .EnvFile}}
+EnvironmentFile=-{{unit .EnvFile}}
+{{- end}}
+{{- if .User}}
+User={{unit .User}}
+{{- end}}
+Restart=always
+RestartSec={{.RestartSec}}s
+TimeoutStopSec={{.StopTimeout}}s
+{{- if .Watchdog}}
+WatchdogSec={{seconds .Watchdog}}s
+{{- end}}
+RuntimeDirectory={{.Name}}
+StateDirectory={{.Name}}
+UMask=0077
+
+# 安全加固
+NoNewPrivileges=yes
+CapabilityBoundingSet={{join .Capabilities " "}}
+{{- if .Capabilities}}
+AmbientCapabilities={{join .Capabilities " "}}
+{{- end}}
+ProtectSystem=strict
+ProtectHome=read-only
+{{- range .WritePaths}}
+ReadWritePaths=-{{unit .}}
+{{- end}}
+PrivateTmp={{if .PrivateTmp}}yes{{else}}no{{end}}
+PrivateDevices=yes
+ProtectProc=invisible
+ProtectClock=yes
+ProtectHostname=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+SystemCallErrorNumber=EPERM
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// systemdSocket systemd 套接字激活单元
+var systemdSocket = template.Must(template.New("socket").Funcs(funcs).Parse(`# {{.Name}}.socket
+# 由 autossh install-service 生成
+
+[Unit]
+Description={{unit .Description}} (sockets)
+PartOf={{.Name}}.service
+
+[Socket]
+{{- range .Listen}}
+ListenStream={{unit .}}
+{{- end}}
+
+[Install]
+WantedBy=sockets.target
+`))
+
+// openrcScript OpenRC 初始化脚本，由 supervise-daemon 在进程退出后重启
+var openrcScript = template.Must(template.New("openrc").Funcs(funcs).Parse(`#!/sbin/openrc-run
+# 由 autossh install-service 生成
+
+name={{shquote .Name}}
+description={{shquote .Description}}
+command={{shquote .Binary}}
+command_args="-c {{shquote .ConfigFile}}"
+{{- if .User}}
+command_user={{shquote .User}}
+{{- end}}
+supervisor="supervise-daemon"
+respawn_delay={{.RestartSec}}
+respawn_max=0
+retry="TERM/{{.StopTimeout}}/KILL/5"
+umask=077
+no_new_privs="yes"
+{{- if .Capabilities}}
+capabilities="^{{lower (join .Capabilities ",^")}}"
+{{- end}}
+output_log="/var/log/${RC_SVCNAME}.log"
+error_log="/var/log/${RC_SVCNAME}.log"
+{{- if .EnvFile}}
+
+# 从环境变量文件读取密码等敏感信息
+if [ -f {{shquote .EnvFile}} ]; then
+	set -a
+	. {{shquote .EnvFile}}
+	set +a
+fi
+{{- end}}
+
+depend() {
+	need net
+	after firewall
+}
+`))
+
+// launchdPlist launchd 属性列表，退出后由 launchd 重启
+// launchd 不支持环境变量文件，配置了环境变量文件时通过 /bin/sh 读取后启动
+var launchdPlist = template.Must(template.New("launchd").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<!-- 由 autossh install-service 生成 -->
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Name}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- if .EnvFile}}
+		<string>/bin/sh</string>
+		<string>-c</string>
+		<string>{{xml (printf "set -a; [ -f %s ] && . %s; set +a; exec %s -c %s" (shquote .EnvFile) (shquote .EnvFile) (shquote .Binary) (shquote .ConfigFile))}}</string>
+{{- else}}
+		<string>{{xml .Binary}}</string>
+		<string>-c</string>
+		<string>{{xml .ConfigFile}}</string>
+{{- end}}
+	</array>
+{{- if .User}}
+	<key>UserName</key>
+	<string>{{xml .User}}</string>
+{{- end}}
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ThrottleInterval</key>
+	<integer>{{.RestartSec}}</integer>
+	<key>ExitTimeOut</key>
+	<integer>{{.StopTimeout}}</integer>
+	<key>Umask</key>
+	<integer>63</integer>
+	<key>StandardOutPath</key>
+	<string>/usr/local/var/log/{{xml .Name}}.log</string>
+	<key>StandardErrorPath</key>
+	<string>/usr/local/var/log/{{xml .Name}}.log</string>
+</dict>
+</plist>
+`))
+
+// envFile 环境变量文件
+var envFile = template.Must(template.New("env").Funcs(funcs).Parse(`# {{.Name}} 的环境变量，保存密码等敏感信息
+# 设置后覆盖配置文件中的 auth.password 和 auth.passphrase，文件权限应为 0600
+{{- range .Env}}
+#{{.}}=
+{{- end}}
+`))
+
+// quote 为包含空白或引号的参数加上 systemd 使用的双引号，并转义说明符 '%'
+func quote(s string) string {
+	if strings.ContainsAny(s, " \t\"'\\") {
+		s = strconv.Quote(s)
+	}
+	return unitEscape(s)
+}
+
+// unitEscape 转义 systemd 单元文件中的说明符 '%' (例如 %h、%n)
+func unitEscape(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// shquote 为 shell 参数加上单引号
+func shquote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// xmlEscape 转义 XML 文本
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// maxLineSize 写入日志时单行输出的最大长度，超出部分拆分为多行
+const maxLineSize = 64 * 1024
+
+// Runner 在SSH连接上执行远程命令
+// 每次连接建立后由监控器调用 Start，连接断开前调用 Stop
+type Runner struct {
+	client *ssh.Client
+	cfg    config.SessionConfig
+
+	mu      sync.Mutex
+	session *gossh.Session
+	done    chan error
+}
+
+// NewRunner 创建远程命令执行器
+func NewRunner(client *ssh.Client, cfg config.SessionConfig) *Runner {
+	return &Runner{
+		client: client,
+		cfg:    cfg,
+	}
+}
+
+// Start 在当前连接上启动远程命令
+func (r *Runner) Start() error {
+	session, err := r.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("创建会话失败: %w", err)
+	}
+
+	for _, env := range r.cfg.Env {
+		name, value, _ := strings.Cut(env, "=")
+		if err := session.Setenv(name, value); err != nil {
+			// 服务器未通过 AcceptEnv 允许时拒绝，不影响命令执行
+			slog.Warn("服务器拒绝设置环境变量", "name", name, "error", err)
+		}
+	}
+
+	if r.cfg.PTY {
+		modes := gossh.TerminalModes{
+			gossh.ECHO:          0,
+			gossh.TTY_OP_ISPEED: 14400,
+			gossh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty("xterm", 24, 80, modes); err != nil {
+			session.Close()
+			return fmt.Errorf("请求伪终端失败: %w", err)
+		}
+	}
+
+	closeOutput, err := r.setOutput(session)
+	if err != nil {
+		session.Close()
+		return err
+	}
+
+	if err := session.Start(r.cfg.Command); err != nil {
+		session.Close()
+		closeOutput()
+		return fmt.Errorf("启动远程命令失败: %w", err)
+	}
+	slog.Info("远程命令已启动", "command", r.cfg.Command, "pty", r.cfg.PTY)
+
+	done := make(chan error, 1)
+	r.mu.Lock()
+	r.session = session
+	r.done = done
+	r.mu.Unlock()
+
+	go func() {
+		err := session.Wait()
+		closeOutput()
+		session.Close()
+		done <- err
+	}()
+	return nil
+}
+
+// Done 返回当前命令的退出通知，命令结束时发送 session.Wait 的结果
+// 未启动命令时返回 nil (永远阻塞)
+func (r *Runner) Done() <-chan error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+// Stop 结束远程命令
+func (r *Runner) Stop() {
+	r.mu.Lock()
+	session := r.session
+	r.session = nil
+	r.done = nil
+	r.mu.Unlock()
+
+	if session == nil {
+		return
+	}
+	// 先请求命令退出，关闭会话后服务器会结束进程
+	session.Signal(gossh.SIGTERM)
+	session.Close()
+	slog.Debug("远程命令已停止", "command", r.cfg.Command)
+}
+
+// setOutput 根据配置设置命令输出，返回的函数在命令结束后关闭输出
+func (r *Runner) setOutput(session *gossh.Session) (func(), error) {
+	switch r.cfg.Output {
+	case "", config.SessionOutputLog:
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		stderr, err := session.StderrPipe()
+		if err != nil {
+			return nil, err
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			logLines(stdout, "stdout")
+		}()
+		go func() {
+			defer wg.Done()
+			logLines(stderr, "stderr")
+		}()
+		// 等待剩余输出写入日志
+		return wg.Wait, nil
+
+	case config.SessionOutputDiscard:
+		return func() {}, nil
+
+	default:
+		f, err := os.OpenFile(r.cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("打开远程命令输出文件失败: %w", err)
+		}
+		session.Stdout = f
+		session.Stderr = f
+		return func() { f.Close() }, nil
+	}
+}
+
+// logLines 按行将命令输出写入日志，超长的行拆分为多条
+func logLines(r io.Reader, stream string) {
+	reader := bufio.NewReaderSize(r, maxLineSize)
+	for {
+		line, _, err := reader.ReadLine()
+		if len(line) > 0 || err == nil {
+			slog.Info("远程命令输出", "stream", stream, "line", strings.TrimRight(string(line), "\r"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
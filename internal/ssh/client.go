@@ -1,10 +1,12 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"autossh/internal/config"
@@ -13,11 +15,16 @@ import (
 )
 
 // Client SSH客户端
+// 配置 connections 大于 1 时在主连接之外维持附加连接，新通道分配到负载最低的连接
 type Client struct {
 	cfg    *config.Config
 	conn   *ssh.Client
 	mu     sync.RWMutex
 	closed bool
+
+	active   atomic.Int64 // 主连接上通过 Dial 打开的通道数
+	pool     []*member    // 附加连接
+	poolStop chan struct{}
 }
 
 // NewClient 创建新的SSH客户端
@@ -32,6 +39,7 @@ func (c *Client) Connect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.stopPool()
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
@@ -64,15 +72,47 @@ func (c *Client) Connect() error {
 	c.closed = false
 	slog.Info("SSH连接已建立", "address", address)
 
+	// 附加连接复用主连接的认证信息，在后台建立
+	if c.cfg.Connections > 1 {
+		c.startPool(func() (*ssh.Client, error) {
+			return ssh.Dial("tcp", address, sshConfig)
+		})
+	}
+
 	return nil
 }
 
+// startPool 启动附加连接，调用方需持有写锁
+func (c *Client) startPool(dial func() (*ssh.Client, error)) {
+	stop := make(chan struct{})
+	c.poolStop = stop
+	c.pool = make([]*member, c.cfg.Connections-1)
+	for i := range c.pool {
+		m := &member{index: i + 1}
+		c.pool[i] = m
+		go m.run(dial, c.cfg.Reconnect.Interval, stop)
+	}
+	slog.Info("连接池已启动", "connections", c.cfg.Connections)
+}
+
+// stopPool 通知附加连接关闭，调用方需持有写锁
+// 正在建立的附加连接在握手完成后关闭，不等待
+func (c *Client) stopPool() {
+	if c.poolStop == nil {
+		return
+	}
+	close(c.poolStop)
+	c.poolStop = nil
+	c.pool = nil
+}
+
 // Close 关闭SSH连接
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.closed = true
+	c.stopPool()
 	if c.conn != nil {
 		err := c.conn.Close()
 		c.conn = nil
@@ -103,6 +143,7 @@ func (c *Client) IsConnected() bool {
 }
 
 // Dial 通过SSH隧道建立连接
+// 使用连接池时选择打开通道最少的连接，附加连接不可用时回退到主连接
 func (c *Client) Dial(network, address string) (net.Conn, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -111,7 +152,28 @@ func (c *Client) Dial(network, address string) (net.Conn, error) {
 		return nil, fmt.Errorf("SSH未连接")
 	}
 
-	return c.conn.Dial(network, address)
+	conn, active := c.conn, &c.active
+	for _, m := range c.pool {
+		if mc := m.get(); mc != nil && m.active.Load() < active.Load() {
+			conn, active = mc, &m.active
+		}
+	}
+
+	nc, err := conn.Dial(network, address)
+	if err != nil && conn != c.conn {
+		// 服务器拒绝打开通道时不重试，其他错误说明附加连接已失效
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			return nil, err
+		}
+		slog.Debug("附加连接建立通道失败，改用主连接", "error", err)
+		conn, active = c.conn, &c.active
+		nc, err = conn.Dial(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newPooledConn(nc, active), nil
 }
 
 // Listen 在远程服务器上监听端口
@@ -126,6 +188,29 @@ func (c *Client) Listen(network, address string) (net.Listener, error) {
 	return c.conn.Listen(network, address)
 }
 
+// NewSession 在SSH连接上创建新会话
+func (c *Client) NewSession() (*ssh.Session, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.conn == nil {
+		return nil, fmt.Errorf("SSH未连接")
+	}
+
+	return c.conn.NewSession()
+}
+
+// Run 在远程服务器上执行命令并等待其结束
+func (c *Client) Run(cmd string) error {
+	session, err := c.NewSession()
+	if err != nil {
+		return fmt.Errorf("创建会话失败: %w", err)
+	}
+	defer session.Close()
+
+	return session.Run(cmd)
+}
+
 // GetConn 获取底层SSH连接（用于高级操作）
 func (c *Client) GetConn() *ssh.Client {
 	c.mu.RLock()
@@ -146,15 +231,17 @@ func (c *Client) KeepAlive() error {
 	return err
 }
 
-// StartKeepAlive 启动保活goroutine
-func (c *Client) StartKeepAlive(interval time.Duration, errChan chan<- error) {
+// StartKeepAlive 启动保活goroutine，连接关闭或重新建立后退出
+// 保活成功时向 alive 发送通知 (不阻塞，alive 为 nil 时不发送)
+func (c *Client) StartKeepAlive(interval time.Duration, errChan chan<- error, alive chan<- struct{}) {
+	conn := c.GetConn()
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
 			<-ticker.C
-			if c.IsClosed() {
+			if c.IsClosed() || c.GetConn() != conn {
 				return
 			}
 
@@ -164,6 +251,12 @@ func (c *Client) StartKeepAlive(interval time.Duration, errChan chan<- error) {
 				return
 			}
 			slog.Debug("保活请求成功")
+			if alive != nil {
+				select {
+				case alive <- struct{}{}:
+				default:
+				}
+			}
 		}
 	}()
 }
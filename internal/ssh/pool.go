@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 连接池成员的保活间隔和最大重连间隔
+const (
+	memberKeepAlive  = 30 * time.Second
+	memberMaxBackoff = 60 * time.Second
+)
+
+// member 连接池中的附加连接
+// 只承载本地发起的通道 (Dial)，远程转发、会话和保活仍使用主连接
+type member struct {
+	index  int
+	mu     sync.RWMutex
+	conn   *ssh.Client
+	active atomic.Int64 // 当前打开的通道数
+}
+
+// get 返回成员当前的连接，未连接时为 nil
+func (m *member) get() *ssh.Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.conn
+}
+
+// set 更新成员的连接
+func (m *member) set(conn *ssh.Client) {
+	m.mu.Lock()
+	m.conn = conn
+	m.mu.Unlock()
+}
+
+// run 维持成员连接，断开后按退避间隔独立重连，直到 stop 关闭
+func (m *member) run(dial func() (*ssh.Client, error), interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	backoff := interval
+
+	for {
+		conn, err := dial()
+		if err != nil {
+			slog.Warn("连接池成员连接失败", "member", m.index, "error", err, "retry", backoff)
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, memberMaxBackoff)
+			continue
+		}
+		backoff = interval
+
+		select {
+		case <-stop:
+			conn.Close()
+			return
+		default:
+		}
+
+		m.set(conn)
+		slog.Debug("连接池成员已连接", "member", m.index)
+
+		lost := m.watch(conn, stop)
+		m.set(nil)
+		conn.Close()
+		if !lost {
+			return
+		}
+		slog.Warn("连接池成员断开，准备重连", "member", m.index)
+	}
+}
+
+// watch 定期发送保活请求，连接断开时返回 true，stop 关闭时返回 false
+func (m *member) watch(conn *ssh.Client, stop <-chan struct{}) bool {
+	closed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(memberKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-closed:
+			return true
+		case <-ticker.C:
+			if _, _, err := conn.SendRequest("keepalive@autossh", true, nil); err != nil {
+				return true
+			}
+		}
+	}
+}
+
+// pooledConn 通过连接池建立的连接，关闭时减少所在连接的通道计数
+type pooledConn struct {
+	net.Conn
+	active *atomic.Int64
+	once   sync.Once
+}
+
+// newPooledConn 记录一个打开的通道
+func newPooledConn(conn net.Conn, active *atomic.Int64) *pooledConn {
+	active.Add(1)
+	return &pooledConn{Conn: conn, active: active}
+}
+
+// Close 关闭连接
+func (c *pooledConn) Close() error {
+	c.once.Do(func() { c.active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite 关闭底层连接的写入端
+func (c *pooledConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package systemd 实现 systemd 服务所需的通知协议 (sd_notify)、看门狗和套接字激活 (LISTEN_FDS)
+// 不在 systemd 下运行时 (未设置相应环境变量) 各函数不执行任何操作
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 通知消息
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// listenFdsStart 套接字激活传递的第一个文件描述符
+const listenFdsStart = 3
+
+// Enabled 是否由 systemd 以 Type=notify 启动
+func Enabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Notify 向 systemd 发送状态通知，多个字段以换行分隔
+// 未设置 NOTIFY_SOCKET 时不发送
+func Notify(state ...string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// 以 @ 开头的是抽象命名空间套接字
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("连接 systemd 通知套接字失败: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(state, "\n"))); err != nil {
+		return fmt.Errorf("发送 systemd 通知失败: %w", err)
+	}
+	return nil
+}
+
+// Status 返回 STATUS 通知字段
+func Status(format string, args ...any) string {
+	status := fmt.Sprintf(format, args...)
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// WatchdogInterval 返回 systemd 看门狗超时 (WatchdogSec)，未启用时返回 0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// ListenFiles 返回套接字激活传递的文件描述符，名称来自 FileDescriptorName=
+// 读取后清除相关环境变量，避免传递给子进程
+func ListenFiles() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, 0, count)
+	for i := range count {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files
+}
@@ -0,0 +1,205 @@
+package tunnel
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"autossh/internal/config"
+)
+
+// resolveTimeout 本地解析目标域名的超时时间
+const resolveTimeout = 10 * time.Second
+
+// destACL 目标地址访问控制
+type destACL struct {
+	allow        []config.ACLEntry
+	deny         []config.ACLEntry
+	hasPrefix    bool // 是否包含 IP 或 CIDR 条目
+	resolveLocal bool // 在本地解析目标域名并检查解析出的地址
+}
+
+// newDestACL 编译目标访问控制，未配置时返回 nil
+func newDestACL(cfg config.ACL) (*destACL, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	a := &destACL{resolveLocal: cfg.Resolve == "local"}
+	for _, s := range cfg.Allow {
+		entry, err := config.ParseACLEntry(s)
+		if err != nil {
+			return nil, err
+		}
+		a.allow = append(a.allow, entry)
+	}
+	for _, s := range cfg.Deny {
+		entry, err := config.ParseACLEntry(s)
+		if err != nil {
+			return nil, err
+		}
+		a.deny = append(a.deny, entry)
+	}
+	for _, entries := range [][]config.ACLEntry{a.allow, a.deny} {
+		for _, entry := range entries {
+			a.hasPrefix = a.hasPrefix || entry.Prefix.IsValid()
+		}
+	}
+	return a, nil
+}
+
+// permits 检查是否允许访问目标地址 (host:port)，a 为 nil 时全部允许
+// 默认 IP 和 CIDR 条目只匹配以 IP 形式请求的目标，域名由 SSH 服务器解析；
+// resolveLocal 时先在本地解析域名，所有解析出的地址都通过检查才允许，解析失败时拒绝。
+// 连接时服务器会重新解析域名，结果可能与本地不同 (DNS 重绑定、服务器使用不同的 DNS)，本地解析无法完全防止
+func (a *destACL) permits(address string) bool {
+	if !a.permitsResolved(address, netip.Addr{}) {
+		return false
+	}
+	if a == nil || !a.resolveLocal {
+		return true
+	}
+
+	host, _, _ := net.SplitHostPort(address)
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		slog.Debug("本地解析目标失败", "target", address, "error", err)
+		return false
+	}
+	for _, addr := range addrs {
+		if !a.permitsResolved(address, addr.Unmap()) {
+			return false
+		}
+	}
+	return len(addrs) > 0
+}
+
+// permitsResolved 与 permits 相同，resolved 有效时 IP 和 CIDR 条目同时匹配目标域名在本地解析出的地址
+func (a *destACL) permitsResolved(address string, resolved netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	addr, ipErr := netip.ParseAddr(host)
+	if ipErr == nil {
+		addr = addr.WithZone("")
+	} else if a.hasPrefix && numericHost(host) {
+		// 非标准形式的数字地址可能被解析为任意 IP，无法与 IP 和 CIDR 条目比较
+		return false
+	}
+
+	matches := func(entries []config.ACLEntry) bool {
+		for _, e := range entries {
+			if port < e.PortLow || port > e.PortHigh {
+				continue
+			}
+			switch {
+			case e.Any:
+				return true
+			case e.Prefix.IsValid():
+				if ipErr == nil && e.Prefix.Contains(addr.Unmap()) {
+					return true
+				}
+				if resolved.IsValid() && e.Prefix.Contains(resolved.Unmap()) {
+					return true
+				}
+			case strings.HasPrefix(e.Host, "*."):
+				if strings.HasSuffix(host, e.Host[1:]) {
+					return true
+				}
+			default:
+				if host == e.Host {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if matches(a.deny) {
+		return false
+	}
+	return len(a.allow) == 0 || matches(a.allow)
+}
+
+// numericHost 判断无法解析为 IP 的主机名是否为数字形式，例如 2852039166、0xa9fea9fe、169.254.43518、0251.0376.0251.0376
+// 部分解析器 (inet_aton) 会把这类主机名当作 IP 地址，而合法域名的最后一段不会全是数字
+func numericHost(host string) bool {
+	label := host[strings.LastIndexByte(host, '.')+1:]
+	if hex, ok := strings.CutPrefix(label, "0x"); ok {
+		return strings.Trim(hex, "0123456789abcdef") == ""
+	}
+	return label != "" && strings.Trim(label, "0123456789") == ""
+}
+
+// sourceACL 来源地址访问控制
+type sourceACL struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// newSourceACL 编译来源访问控制，未配置时返回 nil
+func newSourceACL(cfg config.ACL) *sourceACL {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	// 配置已在 Validate 中校验
+	a := &sourceACL{}
+	for _, s := range cfg.Allow {
+		if prefix, err := config.ParsePrefix(s); err == nil {
+			a.allow = append(a.allow, prefix.Masked())
+		}
+	}
+	for _, s := range cfg.Deny {
+		if prefix, err := config.ParsePrefix(s); err == nil {
+			a.deny = append(a.deny, prefix.Masked())
+		}
+	}
+	return a
+}
+
+// permits 检查是否允许来源地址连接，a 为 nil 或非 TCP 连接时全部允许
+func (a *sourceACL) permits(remote net.Addr) bool {
+	if a == nil {
+		return true
+	}
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	addr := tcpAddr.AddrPort().Addr().Unmap()
+
+	for _, prefix := range a.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, prefix := range a.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
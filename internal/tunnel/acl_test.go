@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"testing"
+
+	"autossh/internal/config"
+)
+
+func TestDestACLPermits(t *testing.T) {
+	acl, err := newDestACL(config.ACL{
+		Allow: []string{"*.example.com", "10.0.0.0/8", "[fd00::/8]:443"},
+		Deny:  []string{"169.254.0.0/16", "10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		address string
+		want    bool
+	}{
+		{"www.example.com:443", true},
+		{"www.example.org:443", false},
+		{"10.1.2.3:22", true},
+		{"10.0.0.1:22", false},
+		{"[::ffff:10.1.2.3]:22", true},
+		{"[fd00::1]:443", true},
+		{"[fd00::1%eth0]:443", true},
+		{"[fd00::1]:80", false},
+		{"169.254.169.254:80", false},
+		// 非标准形式的数字地址
+		{"2852039166:80", false},
+		{"0xa9fea9fe:80", false},
+		{"0XA9FEA9FE:80", false},
+		{"169.254.43518:80", false},
+		{"0251.0376.0251.0376:80", false},
+		{"010.0.0.2:22", false},
+		{"1x.example.com:80", true},
+		{"www.example.com.:443", true},
+		{"no-port", false},
+	}
+	for _, c := range cases {
+		if got := acl.permits(c.address); got != c.want {
+			t.Errorf("permits(%q) = %v, 期望 %v", c.address, got, c.want)
+		}
+	}
+}
+
+func TestDestACLNumericHostWithoutPrefix(t *testing.T) {
+	// 没有 IP 和 CIDR 条目时数字形式的主机名按普通主机名匹配
+	acl, err := newDestACL(config.ACL{Deny: []string{"*.internal"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acl.permits("2852039166:80") {
+		t.Error("没有 CIDR 条目时拒绝了数字形式的主机名")
+	}
+}
+
+func TestDestACLResolveLocal(t *testing.T) {
+	acl, err := newDestACL(config.ACL{Deny: []string{"127.0.0.0/8", "::1"}, Resolve: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"localhost:80", "LOCALHOST.:80", "does-not-exist.invalid:80"} {
+		if acl.permits(address) {
+			t.Errorf("permits(%q) = true, 期望 false", address)
+		}
+	}
+	if !acl.permits("192.0.2.1:80") {
+		t.Error("拒绝了未被禁止的 IP 目标")
+	}
+}
@@ -0,0 +1,81 @@
+package tunnel
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	"autossh/internal/config"
+)
+
+// inheritedSocket 由 systemd 套接字激活传递的监听套接字
+type inheritedSocket struct {
+	file *os.File
+	addr net.Addr
+}
+
+var (
+	inheritedMu sync.Mutex
+	inherited   []inheritedSocket
+)
+
+// Inherit 登记 systemd 套接字激活传递的监听套接字
+// 本地转发和动态转发的监听地址与之相同时使用该套接字，不再自行监听 (可由 systemd 绑定特权端口)
+// 隧道每次启动时复制文件描述符，停止时只关闭副本，重连期间的新连接在内核队列中等待
+func Inherit(files []*os.File) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	for _, f := range files {
+		listener, err := net.FileListener(f)
+		if err != nil {
+			slog.Warn("忽略 systemd 传递的非监听套接字", "name", f.Name(), "error", err)
+			continue
+		}
+		addr := listener.Addr()
+		listener.Close()
+
+		inherited = append(inherited, inheritedSocket{file: f, addr: addr})
+		slog.Info("收到 systemd 监听套接字", "name", f.Name(), "addr", addr)
+	}
+}
+
+// inheritedListener 返回监听地址相同的 systemd 套接字，没有时 ok 为 false
+func inheritedListener(bind string) (listener net.Listener, ok bool, err error) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	for _, s := range inherited {
+		if !sameAddr(s.addr, bind) {
+			continue
+		}
+		listener, err := net.FileListener(s.file)
+		if err == nil {
+			slog.Debug("使用 systemd 传递的监听套接字", "bind", bind, "name", s.file.Name())
+		}
+		return listener, true, err
+	}
+	return nil, false, nil
+}
+
+// sameAddr 检查套接字地址与配置的监听地址是否相同，未指定的地址 (0.0.0.0 与 ::) 视为相同
+func sameAddr(addr net.Addr, bind string) bool {
+	switch a := addr.(type) {
+	case *net.UnixAddr:
+		return config.IsUnixSocket(bind) && a.Name == bind
+	case *net.TCPAddr:
+		if config.IsUnixSocket(bind) {
+			return false
+		}
+		b, err := net.ResolveTCPAddr("tcp", bind)
+		if err != nil || a.Port != b.Port {
+			return false
+		}
+		if b.IP == nil || b.IP.IsUnspecified() {
+			return a.IP.IsUnspecified()
+		}
+		return a.IP.Equal(b.IP)
+	}
+	return false
+}
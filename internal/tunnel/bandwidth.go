@@ -0,0 +1,288 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"autossh/internal/config"
+)
+
+const (
+	// shapeChunkSize 限速时单次读取的最大字节数，避免大块突发
+	shapeChunkSize = 16 * 1024
+
+	// quotaSaveInterval 配额用量写入磁盘的最小间隔
+	quotaSaveInterval = 10 * time.Second
+)
+
+// Bandwidth 全局带宽限制和流量配额
+// 由 Manager 持有，在重连之间保持
+type Bandwidth struct {
+	upload   *tokenBucket
+	download *tokenBucket
+	quota    *quota
+}
+
+// NewBandwidth 创建全局带宽限制，未配置任何限制时返回 nil
+func NewBandwidth(cfg config.BandwidthConfig) *Bandwidth {
+	// 配置已在 Validate 中校验
+	upload, _ := config.ParseByteSize(cfg.UploadRate)
+	download, _ := config.ParseByteSize(cfg.DownloadRate)
+
+	b := &Bandwidth{
+		upload:   newTokenBucket(upload),
+		download: newTokenBucket(download),
+	}
+	if cfg.QuotaEnabled() {
+		b.quota = newQuota(cfg)
+	}
+
+	if b.upload == nil && b.download == nil && b.quota == nil {
+		return nil
+	}
+	return b
+}
+
+// Save 将配额用量写入磁盘
+func (b *Bandwidth) Save() {
+	if b == nil || b.quota == nil {
+		return
+	}
+	if err := b.quota.save(); err != nil {
+		slog.Warn("保存流量配额用量失败", "file", b.quota.file, "error", err)
+	}
+}
+
+// tokenBucket 令牌桶限速器，令牌单位为字节，容量为一秒的速率
+type tokenBucket struct {
+	rate   float64 // 每秒字节数
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建令牌桶，rate 不大于 0 时返回 nil (不限速)
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// wait 消耗 n 个令牌，不足时等待
+// 允许透支，透支部分由之后的调用者等待偿还，多个连接共享时总速率不超过限制
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// quota 每日/每月流量配额，用量按本地时间统计并保存到磁盘
+type quota struct {
+	daily   int64
+	monthly int64
+	action  string
+	file    string
+
+	mu        sync.Mutex
+	saveMu    sync.Mutex // 串行写入文件
+	usage     quotaUsage
+	lastSave  time.Time
+	dirty     bool
+	exhausted bool // 已记录用尽日志
+}
+
+// quotaUsage 保存到磁盘的用量
+type quotaUsage struct {
+	Day        string `json:"day"` // 2006-01-02
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"` // 2006-01
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// newQuota 创建配额并从磁盘恢复用量
+func newQuota(cfg config.BandwidthConfig) *quota {
+	daily, _ := config.ParseByteSize(cfg.DailyQuota)
+	monthly, _ := config.ParseByteSize(cfg.MonthlyQuota)
+
+	q := &quota{
+		daily:   daily,
+		monthly: monthly,
+		action:  cfg.QuotaAction,
+		file:    cfg.QuotaFile,
+	}
+	if q.action == "" {
+		q.action = config.QuotaReject
+	}
+	if q.file == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			q.file = filepath.Join(home, ".autossh", "quota.json")
+		}
+	}
+
+	if data, err := os.ReadFile(q.file); err == nil {
+		if err := json.Unmarshal(data, &q.usage); err != nil {
+			slog.Warn("流量配额用量文件无效，重新统计", "file", q.file, "error", err)
+			q.usage = quotaUsage{}
+		}
+	}
+	q.rollover(time.Now())
+
+	slog.Info("流量配额", "daily", cfg.DailyQuota, "monthly", cfg.MonthlyQuota,
+		"used_today", q.usage.DayBytes, "used_month", q.usage.MonthBytes, "action", q.action)
+	return q
+}
+
+// rollover 进入新的一天或一个月时清零用量，调用时需持有锁 (或在初始化时)
+func (q *quota) rollover(now time.Time) {
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	if q.usage.Day != day {
+		q.usage.Day, q.usage.DayBytes = day, 0
+		q.exhausted = false
+		q.dirty = true
+	}
+	if q.usage.Month != month {
+		q.usage.Month, q.usage.MonthBytes = month, 0
+		q.exhausted = false
+		q.dirty = true
+	}
+}
+
+// add 累计用量，定期写入磁盘
+func (q *quota) add(n int) {
+	q.mu.Lock()
+	now := time.Now()
+	q.rollover(now)
+	q.usage.DayBytes += int64(n)
+	q.usage.MonthBytes += int64(n)
+	q.dirty = true
+
+	if !q.exhausted && q.exceededLocked() {
+		q.exhausted = true
+		slog.Warn("流量配额已用尽", "used_today", q.usage.DayBytes, "used_month", q.usage.MonthBytes, "action", q.action)
+	}
+
+	save := now.Sub(q.lastSave) >= quotaSaveInterval
+	q.mu.Unlock()
+
+	if save {
+		if err := q.save(); err != nil {
+			slog.Warn("保存流量配额用量失败", "file", q.file, "error", err)
+		}
+	}
+}
+
+// exceeded 配额是否已用尽
+func (q *quota) exceeded() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover(time.Now())
+	return q.exceededLocked()
+}
+
+// exceededLocked 配额是否已用尽，调用时需持有锁
+func (q *quota) exceededLocked() bool {
+	return (q.daily > 0 && q.usage.DayBytes >= q.daily) ||
+		(q.monthly > 0 && q.usage.MonthBytes >= q.monthly)
+}
+
+// wait 暂停模式下等待配额恢复 (进入新的统计周期)
+func (q *quota) wait(ctx context.Context) error {
+	if q.action != config.QuotaPause {
+		return nil
+	}
+	for q.exceeded() {
+		timer := time.NewTimer(time.Until(q.nextReset()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// nextReset 返回下一次清零用量的时间
+func (q *quota) nextReset() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if q.monthly > 0 && q.usage.MonthBytes >= q.monthly {
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+}
+
+// save 将用量写入磁盘 (先写临时文件再重命名)
+// 写入失败时保留未保存标记，下次保存 (包括退出时) 重试
+func (q *quota) save() error {
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	q.mu.Lock()
+	if !q.dirty || q.file == "" {
+		q.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(q.usage, "", "  ")
+	// 写入期间新增的用量会重新设置标记
+	q.dirty = false
+	q.lastSave = time.Now()
+	q.mu.Unlock()
+
+	if err == nil {
+		err = q.write(data)
+	}
+	if err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// write 将数据写入临时文件后重命名为配额文件
+func (q *quota) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(q.file), 0o700); err != nil {
+		return err
+	}
+	tmp := q.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.file)
+}
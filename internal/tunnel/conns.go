@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// forceCloseTimeout 强制关闭连接后等待处理结束的最长时间
+// 通过SSH连接打开通道等操作在连接失效时可能一直阻塞，超时后不再等待，由关闭SSH连接结束
+const forceCloseTimeout = 5 * time.Second
+
+// connGroup 跟踪隧道正在处理的连接
+// 停止时先等待连接自然结束，超过排空时间后取消上下文，关闭所有剩余连接
+// 开始停止后不再接受新连接，所有连接结束时关闭 done
+type connGroup struct {
+	mu     sync.Mutex
+	active int           // 正在处理的连接数
+	closed bool          // 已开始停止
+	done   chan struct{} // 开始停止后，所有连接结束时关闭
+	cancel context.CancelFunc
+}
+
+// init 返回处理连接使用的上下文，parent 取消或 stop 时取消
+func (g *connGroup) init(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cancel = cancel
+	g.closed = false
+	g.done = nil
+	return ctx
+}
+
+// handle 在新的 goroutine 中处理连接，上下文取消时关闭连接以中断阻塞的读写
+// 已开始停止时直接关闭连接
+func (g *connGroup) handle(ctx context.Context, conn net.Conn, handler func(context.Context, net.Conn)) {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		conn.Close()
+		return
+	}
+	g.active++
+	g.mu.Unlock()
+
+	go func() {
+		defer g.release()
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		handler(ctx, conn)
+	}()
+}
+
+// release 记录连接处理结束，停止期间最后一个连接结束时关闭 done
+func (g *connGroup) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.closed && g.active == 0 {
+		close(g.done)
+	}
+}
+
+// stop 等待连接结束，直到 ctx 结束后强制关闭剩余连接，返回强制关闭的连接数
+func (g *connGroup) stop(ctx context.Context, name string) int {
+	g.mu.Lock()
+	if !g.closed {
+		g.closed = true
+		g.done = make(chan struct{})
+		if g.active == 0 {
+			close(g.done)
+		}
+	}
+	done, cancel := g.done, g.cancel
+	g.mu.Unlock()
+
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	cut := g.active
+	g.mu.Unlock()
+	if cut > 0 {
+		slog.Debug("关闭剩余连接", "tunnel", name, "count", cut)
+	}
+	if cancel != nil {
+		cancel()
+	}
+	if !waitClosed(done, forceCloseTimeout) {
+		slog.Warn("部分连接未能及时结束", "tunnel", name)
+	}
+	return cut
+}
+
+// waitClosed 等待 done 关闭，超时返回 false
+func waitClosed(done <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// listenerClosed 检查 Accept 的错误是否表示监听已关闭
+// 本地监听返回 net.ErrClosed，SSH 远程监听返回 io.EOF
+func listenerClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,372 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testSSHServer 接受任意密码并处理 direct-tcpip 通道的 SSH 服务器
+type testSSHServer struct {
+	listener net.Listener
+	config   *gossh.ServerConfig
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// newTestSSHServer 启动测试用 SSH 服务器，测试结束时关闭
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &gossh.ServerConfig{
+		PasswordCallback: func(gossh.ConnMetadata, []byte) (*gossh.Permissions, error) { return nil, nil },
+	}
+	cfg.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{listener: listener, config: cfg, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *testSSHServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *testSSHServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	_, chans, reqs, err := gossh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "direct-tcpip" {
+			newChan.Reject(gossh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var payload struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := gossh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+			newChan.Reject(gossh.ConnectionFailed, err.Error())
+			continue
+		}
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		if err != nil {
+			newChan.Reject(gossh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go gossh.DiscardRequests(chReqs)
+		go func() {
+			io.Copy(ch, target)
+			ch.CloseWrite()
+		}()
+		go func() {
+			io.Copy(target, ch)
+			target.Close()
+			ch.Close()
+		}()
+	}
+}
+
+func (s *testSSHServer) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// newEchoServer 启动回显服务器，测试结束时关闭
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	})
+	return listener.Addr().String()
+}
+
+// freeAddr 返回一个空闲的本地监听地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// echoThrough 发送数据并检查回显
+func echoThrough(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("回显数据 = %q, 期望 ping", buf)
+	}
+}
+
+// socksConnect 通过 SOCKS5 代理连接目标地址 (不认证)
+func socksConnect(t *testing.T, proxy, target string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxy, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	req := []byte{5, 1, 0, 5, 1, 0, 1}
+	req = append(req, net.ParseIP(host).To4()...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != 0 {
+		t.Fatalf("SOCKS5 连接失败: %d", reply[3])
+	}
+	return conn
+}
+
+// waitGoroutines 等待 goroutine 数量回到 baseline，超时则失败
+func waitGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= baseline {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("goroutine 泄漏: %d 个, 基线 %d 个\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestManagerReconnectNoLeak(t *testing.T) {
+	srv := newTestSSHServer(t)
+	echo := newEchoServer(t)
+	localBind, dynamicBind := freeAddr(t), freeAddr(t)
+
+	host, portStr, _ := net.SplitHostPort(srv.listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	cfg := config.DefaultConfig()
+	cfg.Server = config.ServerConfig{Host: host, Port: port, User: "test"}
+	cfg.Auth = config.AuthConfig{Type: "password", Password: "test"}
+	cfg.Relay.DrainTimeout = 50 * time.Millisecond
+	cfg.Tunnels.Local = []config.LocalTunnel{{Bind: localBind, Target: echo}}
+	cfg.Tunnels.Dynamic = []config.DynamicTunnel{{Bind: dynamicBind}}
+
+	baseline := runtime.NumGoroutine()
+
+	client := ssh.NewClient(cfg)
+	mgr := NewManager(client, cfg)
+	var open []net.Conn
+	for cycle := range 5 {
+		if err := client.Connect(); err != nil {
+			t.Fatalf("第 %d 次连接失败: %v", cycle+1, err)
+		}
+		if err := mgr.Start(); err != nil {
+			t.Fatalf("第 %d 次启动隧道失败: %v", cycle+1, err)
+		}
+
+		local, err := net.DialTimeout("tcp", localBind, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		echoThrough(t, local)
+		proxied := socksConnect(t, dynamicBind, echo)
+		echoThrough(t, proxied)
+		// 保持连接打开，停止时需要强制关闭
+		open = append(open, local, proxied)
+
+		// 交替模拟正常停止和连接先断开的情况
+		if cycle%2 == 0 {
+			mgr.Stop()
+			client.Close()
+		} else {
+			client.Close()
+			mgr.Stop()
+		}
+	}
+	for _, conn := range open {
+		conn.Close()
+	}
+
+	waitGoroutines(t, baseline)
+}
+
+func TestConnGroupStopRefusesNewConnections(t *testing.T) {
+	var g connGroup
+	ctx := g.init(context.Background())
+
+	// 连接处理一直阻塞到上下文取消
+	client, server := net.Pipe()
+	defer client.Close()
+	g.handle(ctx, server, func(ctx context.Context, conn net.Conn) { <-ctx.Done() })
+
+	stopped := make(chan int)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		stopped <- g.stop(ctx, "test")
+	}()
+
+	// 等待 stop 开始后再添加连接
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g.mu.Lock()
+		closed := g.closed
+		g.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stop 未开始")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	lateClient, lateServer := net.Pipe()
+	defer lateClient.Close()
+	handled := make(chan struct{}, 1)
+	g.handle(ctx, lateServer, func(context.Context, net.Conn) { handled <- struct{}{} })
+
+	lateClient.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := lateClient.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("停止后的新连接未被关闭: %v", err)
+	}
+	select {
+	case <-handled:
+		t.Fatal("停止后仍处理了新连接")
+	default:
+	}
+
+	if cut := <-stopped; cut != 1 {
+		t.Fatalf("强制关闭的连接数 = %d, 期望 1", cut)
+	}
+}
+
+func TestConnGroupDrainTimeoutNoLeak(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	for range 5 {
+		var g connGroup
+		ctx := g.init(context.Background())
+		for range 3 {
+			client, server := net.Pipe()
+			defer client.Close()
+			g.handle(ctx, server, func(ctx context.Context, conn net.Conn) { <-ctx.Done() })
+		}
+		drain, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if cut := g.stop(drain, "test"); cut != 3 {
+			t.Fatalf("强制关闭的连接数 = %d, 期望 3", cut)
+		}
+		// 重复停止立即返回
+		if cut := g.stop(drain, "test"); cut != 0 {
+			t.Fatalf("重复停止时强制关闭的连接数 = %d, 期望 0", cut)
+		}
+		cancel()
+	}
+
+	waitGoroutines(t, baseline)
+}
@@ -1,12 +1,15 @@
 package tunnel
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"strconv"
 	"sync"
 
 	"autossh/internal/config"
@@ -44,38 +47,109 @@ const (
 	repAddrNotSupported = 0x08
 )
 
+// errNotAllowed 目标地址不允许访问
+var errNotAllowed = errors.New("目标地址不允许访问")
+
 // DynamicTunnel 动态端口转发隧道 (-D)
-// 实现 SOCKS5 代理协议
+// 在同一端口上实现 SOCKS5、SOCKS4/4a 和 HTTP 代理协议
 type DynamicTunnel struct {
 	client   *ssh.Client
 	spec     config.DynamicTunnel
+	socks    *socksServer
+	sources  *sourceACL
+	limiter  *connLimiter
+	tls      *tlsTerminator // 为 nil 时不启用 TLS
 	listener net.Listener
 	mu       sync.Mutex
-	wg       sync.WaitGroup
+	conns    connGroup
 }
 
 // NewDynamicTunnel 创建动态转发隧道
-func NewDynamicTunnel(client *ssh.Client, spec config.DynamicTunnel) *DynamicTunnel {
-	return &DynamicTunnel{
-		client: client,
-		spec:   spec,
+func NewDynamicTunnel(client *ssh.Client, spec config.DynamicTunnel, bw *Bandwidth) *DynamicTunnel {
+	limiter := newConnLimiter(spec.Bind, spec.ConnLimits, false, bw)
+	t := &DynamicTunnel{
+		client:  client,
+		spec:    spec,
+		socks:   &socksServer{dial: client.Dial, limiter: limiter},
+		sources: newSourceACL(spec.Sources),
+		limiter: limiter,
+	}
+	if spec.UDP.Enabled {
+		t.socks.udp = newUDPAssociator(client, spec.UDP)
+	}
+	return t
+}
+
+// socksServer 代理协议处理 (SOCKS5、SOCKS4/4a、HTTP CONNECT 和 HTTP 代理)
+// 正向 (-D) 和反向 (-R port) 动态转发共用，区别仅在于连接目标的方式
+type socksServer struct {
+	dial    func(network, address string) (net.Conn, error)
+	creds   socksCredentials // 为 nil 时不认证
+	udp     *udpAssociator   // 为 nil 时不支持 UDP ASSOCIATE
+	acl     *destACL         // 为 nil 时不限制目标地址
+	limiter *connLimiter     // 转发时的超时和限速
+}
+
+// connect 检查目标访问控制后连接目标
+func (s *socksServer) connect(network, address string) (net.Conn, error) {
+	if !s.acl.permits(address) {
+		return nil, fmt.Errorf("%w: %s (访问控制拒绝)", errNotAllowed, address)
 	}
+	return s.dial(network, address)
 }
 
 // Start 启动隧道
 func (t *DynamicTunnel) Start(ctx context.Context) error {
 	t.mu.Lock()
 
-	// 在本地监听
-	listener, err := net.Listen("tcp", t.spec.Bind)
+	// 加载认证用户，每次启动重新读取用户文件
+	creds, err := loadSocksCredentials(t.spec.Auth)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.socks.creds = creds
+
+	// 加载路由规则，未配置时全部通过SSH隧道连接
+	if t.spec.Routing.Enabled() {
+		r, err := newRouter(t.spec.Routing, t.client.Dial)
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		t.socks.dial = r.dial
+	}
+
+	// 目标访问控制
+	acl, err := newDestACL(t.spec.Destinations)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.socks.acl = acl
+
+	// 加载 TLS 证书
+	terminator, err := newTLSTerminator(t.spec.TLS)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.tls = terminator
+
+	// 在本地监听，systemd 传递了相同地址的套接字时直接使用
+	listener, ok, err := inheritedListener(t.spec.Bind)
+	if !ok {
+		listener, err = net.Listen("tcp", t.spec.Bind)
+	}
 	if err != nil {
 		t.mu.Unlock()
 		return fmt.Errorf("SOCKS5监听失败 %s: %w", t.spec.Bind, err)
 	}
 	t.listener = listener
+	ctx = t.conns.init(ctx)
 	t.mu.Unlock()
 
-	slog.Info("SOCKS5代理已启动", "bind", t.spec.Bind)
+	slog.Info("SOCKS5代理已启动", "bind", t.spec.Bind, "auth", creds != nil, "tls", terminator != nil, "protocols", "socks5,socks4,http")
 
 	// 接受连接
 	for {
@@ -87,6 +161,9 @@ func (t *DynamicTunnel) Start(ctx context.Context) error {
 
 		conn, err := listener.Accept()
 		if err != nil {
+			if listenerClosed(err) {
+				return nil
+			}
 			select {
 			case <-ctx.Done():
 				return nil
@@ -96,50 +173,110 @@ func (t *DynamicTunnel) Start(ctx context.Context) error {
 			}
 		}
 
-		t.wg.Add(1)
-		go t.handleConnection(ctx, conn)
+		if !t.sources.permits(conn.RemoteAddr()) {
+			slog.Warn("拒绝来源地址", "from", conn.RemoteAddr(), "bind", t.spec.Bind)
+			conn.Close()
+			continue
+		}
+
+		t.conns.handle(ctx, conn, t.handleConnection)
 	}
 }
 
-// handleConnection 处理 SOCKS5 连接
+// handleConnection 处理代理连接
 func (t *DynamicTunnel) handleConnection(ctx context.Context, conn net.Conn) {
-	defer t.wg.Done()
+	// 剥离 PROXY 协议头，之后使用头部中的原始客户端地址
+	if t.spec.AcceptProxyProtocol {
+		proxied, err := acceptProxyHeader(conn)
+		if err != nil {
+			slog.Warn("拒绝连接", "from", conn.RemoteAddr(), "error", err)
+			return
+		}
+		conn = proxied
+	}
+
+	release, ok := t.limiter.acquire(conn.RemoteAddr())
+	if !ok {
+		return
+	}
+	defer release()
+
+	tlsConn, err := t.tls.server(ctx, conn)
+	if err != nil {
+		slog.Warn("拒绝连接", "from", conn.RemoteAddr(), "error", err)
+		return
+	}
+
+	t.socks.serve(ctx, tlsConn)
+}
+
+// serve 处理一个代理客户端连接，结束时关闭连接
+// 根据首字节识别协议: SOCKS5、SOCKS4/4a，其余按 HTTP 代理处理
+func (s *socksServer) serve(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 
+	bc := newBufferedConn(conn)
+	first, err := bc.r.Peek(1)
+	if err != nil {
+		return
+	}
+
+	switch first[0] {
+	case socks5Version:
+		s.serveSOCKS5(ctx, bc)
+	case socks4Version:
+		s.serveSOCKS4(ctx, bc)
+	default:
+		s.serveHTTP(ctx, bc)
+	}
+}
+
+// serveSOCKS5 处理 SOCKS5 连接
+func (s *socksServer) serveSOCKS5(ctx context.Context, conn net.Conn) {
 	// 握手阶段
-	if err := t.handshake(conn); err != nil {
+	if err := s.handshake(conn); err != nil {
 		slog.Debug("SOCKS5握手失败", "error", err)
 		return
 	}
 
 	// 请求阶段
-	targetAddr, err := t.readRequest(conn)
+	cmd, targetAddr, err := s.readRequest(conn)
 	if err != nil {
 		slog.Debug("读取SOCKS5请求失败", "error", err)
 		return
 	}
 
+	if cmd == cmdUDP {
+		slog.Debug("SOCKS5 UDP关联请求", "from", conn.RemoteAddr())
+		s.udp.associate(ctx, s, conn)
+		return
+	}
+
 	slog.Debug("SOCKS5连接请求", "from", conn.RemoteAddr(), "to", targetAddr)
 
-	// 通过SSH隧道连接目标
-	remoteConn, err := t.client.Dial("tcp", targetAddr)
+	// 连接目标
+	remoteConn, err := s.connect("tcp", targetAddr)
 	if err != nil {
 		slog.Debug("连接目标失败", "target", targetAddr, "error", err)
-		t.sendReply(conn, repHostUnreach, nil)
+		if errors.Is(err, errNotAllowed) {
+			s.sendReply(conn, repNotAllowed, nil)
+		} else {
+			s.sendReply(conn, repHostUnreach, nil)
+		}
 		return
 	}
 	defer remoteConn.Close()
 
 	// 发送成功响应
-	localAddr := conn.LocalAddr().(*net.TCPAddr)
-	t.sendReply(conn, repSuccess, localAddr)
+	localAddr, _ := conn.LocalAddr().(*net.TCPAddr)
+	s.sendReply(conn, repSuccess, localAddr)
 
 	// 双向转发数据
-	bidirectionalCopy(ctx, conn, remoteConn)
+	bidirectionalCopy(ctx, conn, remoteConn, s.limiter)
 }
 
 // handshake SOCKS5 握手
-func (t *DynamicTunnel) handshake(conn net.Conn) error {
+func (s *socksServer) handshake(conn net.Conn) error {
 	// 读取版本和认证方法数量
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(conn, header); err != nil {
@@ -157,41 +294,55 @@ func (t *DynamicTunnel) handshake(conn net.Conn) error {
 		return fmt.Errorf("读取认证方法失败: %w", err)
 	}
 
-	// 检查是否支持无认证
-	hasNoAuth := false
+	// 配置了用户时要求用户名/密码认证，否则使用无认证
+	want := byte(authNone)
+	if s.creds != nil {
+		want = authPassword
+	}
+
+	supported := false
 	for _, m := range methods {
-		if m == authNone {
-			hasNoAuth = true
+		if m == want {
+			supported = true
 			break
 		}
 	}
 
-	if !hasNoAuth {
+	if !supported {
 		conn.Write([]byte{socks5Version, authNoAccept})
-		return fmt.Errorf("客户端不支持无认证")
+		return fmt.Errorf("客户端不支持认证方法: %d", want)
 	}
 
-	// 选择无认证
-	_, err := conn.Write([]byte{socks5Version, authNone})
-	return err
+	if _, err := conn.Write([]byte{socks5Version, want}); err != nil {
+		return err
+	}
+
+	if want == authPassword {
+		if err := s.authenticate(conn); err != nil {
+			slog.Warn("SOCKS5认证失败", "from", conn.RemoteAddr(), "error", err)
+			return err
+		}
+	}
+	return nil
 }
 
-// readRequest 读取 SOCKS5 请求
-func (t *DynamicTunnel) readRequest(conn net.Conn) (string, error) {
+// readRequest 读取 SOCKS5 请求，返回命令和目标地址
+func (s *socksServer) readRequest(conn net.Conn) (byte, string, error) {
 	// 读取请求头: VER | CMD | RSV | ATYP
 	header := make([]byte, 4)
 	if _, err := io.ReadFull(conn, header); err != nil {
-		return "", fmt.Errorf("读取请求头失败: %w", err)
+		return 0, "", fmt.Errorf("读取请求头失败: %w", err)
 	}
 
 	if header[0] != socks5Version {
-		return "", fmt.Errorf("无效的SOCKS版本: %d", header[0])
+		return 0, "", fmt.Errorf("无效的SOCKS版本: %d", header[0])
 	}
 
-	// 只支持 CONNECT 命令
-	if header[1] != cmdConnect {
-		t.sendReply(conn, repCmdNotSupported, nil)
-		return "", fmt.Errorf("不支持的命令: %d", header[1])
+	// 支持 CONNECT 命令，启用时支持 UDP ASSOCIATE
+	cmd := header[1]
+	if cmd != cmdConnect && (cmd != cmdUDP || s.udp == nil) {
+		s.sendReply(conn, repCmdNotSupported, nil)
+		return 0, "", fmt.Errorf("不支持的命令: %d", header[1])
 	}
 
 	// 读取目标地址
@@ -202,7 +353,7 @@ func (t *DynamicTunnel) readRequest(conn net.Conn) (string, error) {
 	case addrTypeIPv4:
 		addr := make([]byte, 4)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return "", fmt.Errorf("读取IPv4地址失败: %w", err)
+			return 0, "", fmt.Errorf("读取IPv4地址失败: %w", err)
 		}
 		host = net.IP(addr).String()
 
@@ -210,38 +361,38 @@ func (t *DynamicTunnel) readRequest(conn net.Conn) (string, error) {
 		// 读取域名长度
 		lenBuf := make([]byte, 1)
 		if _, err := io.ReadFull(conn, lenBuf); err != nil {
-			return "", fmt.Errorf("读取域名长度失败: %w", err)
+			return 0, "", fmt.Errorf("读取域名长度失败: %w", err)
 		}
 		domain := make([]byte, lenBuf[0])
 		if _, err := io.ReadFull(conn, domain); err != nil {
-			return "", fmt.Errorf("读取域名失败: %w", err)
+			return 0, "", fmt.Errorf("读取域名失败: %w", err)
 		}
 		host = string(domain)
 
 	case addrTypeIPv6:
 		addr := make([]byte, 16)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return "", fmt.Errorf("读取IPv6地址失败: %w", err)
+			return 0, "", fmt.Errorf("读取IPv6地址失败: %w", err)
 		}
 		host = net.IP(addr).String()
 
 	default:
-		t.sendReply(conn, repAddrNotSupported, nil)
-		return "", fmt.Errorf("不支持的地址类型: %d", addrType)
+		s.sendReply(conn, repAddrNotSupported, nil)
+		return 0, "", fmt.Errorf("不支持的地址类型: %d", addrType)
 	}
 
 	// 读取端口
 	portBuf := make([]byte, 2)
 	if _, err := io.ReadFull(conn, portBuf); err != nil {
-		return "", fmt.Errorf("读取端口失败: %w", err)
+		return 0, "", fmt.Errorf("读取端口失败: %w", err)
 	}
 	port := binary.BigEndian.Uint16(portBuf)
 
-	return fmt.Sprintf("%s:%d", host, port), nil
+	return cmd, net.JoinHostPort(host, strconv.Itoa(int(port))), nil
 }
 
 // sendReply 发送 SOCKS5 响应
-func (t *DynamicTunnel) sendReply(conn net.Conn, rep byte, addr *net.TCPAddr) {
+func (s *socksServer) sendReply(conn net.Conn, rep byte, addr *net.TCPAddr) {
 	// VER | REP | RSV | ATYP | BND.ADDR | BND.PORT
 	reply := []byte{socks5Version, rep, 0x00, addrTypeIPv4}
 
@@ -262,18 +413,18 @@ func (t *DynamicTunnel) sendReply(conn net.Conn, rep byte, addr *net.TCPAddr) {
 	conn.Write(reply)
 }
 
-// Stop 停止隧道
-func (t *DynamicTunnel) Stop() error {
+// Stop 停止隧道，关闭监听后等待现有连接结束，直到 ctx 结束
+// 返回超时后强制关闭的连接数
+func (t *DynamicTunnel) Stop(ctx context.Context) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	if t.listener != nil {
 		err := t.listener.Close()
 		t.listener = nil
-		t.wg.Wait()
-		return err
+		return t.conns.stop(ctx, t.String()), err
 	}
-	return nil
+	return 0, nil
 }
 
 // Type 返回隧道类型
@@ -286,3 +437,36 @@ func (t *DynamicTunnel) String() string {
 	return fmt.Sprintf("SOCKS5 %s", t.spec.Bind)
 }
 
+// Name 返回隧道名称
+func (t *DynamicTunnel) Name() string {
+	return t.String()
+}
+
+// Bind 返回监听地址
+func (t *DynamicTunnel) Bind() string {
+	return t.spec.Bind
+}
+
+// bufferedConn 带读缓冲的连接，用于协议识别后继续读取已缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newBufferedConn 创建带读缓冲的连接
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Read 从缓冲区读取数据
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite 关闭底层连接的写入端
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
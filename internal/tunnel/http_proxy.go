@@ -0,0 +1,174 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders 不应被代理转发的逐跳头部
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// serveHTTP 处理 HTTP 代理连接 (CONNECT 和绝对 URI 请求)
+func (s *socksServer) serveHTTP(ctx context.Context, conn *bufferedConn) {
+	req, err := http.ReadRequest(conn.r)
+	if err != nil {
+		slog.Debug("读取HTTP代理请求失败", "error", err)
+		return
+	}
+
+	if s.creds != nil && !s.checkProxyAuth(req) {
+		slog.Debug("HTTP代理认证失败", "from", conn.RemoteAddr())
+		writeHTTPError(conn, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"autossh\"\r\n")
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		s.serveHTTPConnect(ctx, conn, req)
+		return
+	}
+	s.serveHTTPForward(ctx, conn, req)
+}
+
+// serveHTTPConnect 处理 HTTP CONNECT 隧道
+func (s *socksServer) serveHTTPConnect(ctx context.Context, conn *bufferedConn, req *http.Request) {
+	targetAddr := withDefaultPort(req.Host, "443")
+	slog.Debug("HTTP CONNECT请求", "from", conn.RemoteAddr(), "to", targetAddr)
+
+	remoteConn, err := s.connect("tcp", targetAddr)
+	if err != nil {
+		slog.Debug("连接目标失败", "target", targetAddr, "error", err)
+		writeHTTPError(conn, dialErrorStatus(err), "")
+		return
+	}
+	defer remoteConn.Close()
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	// 双向转发数据
+	bidirectionalCopy(ctx, conn, remoteConn, s.limiter)
+}
+
+// serveHTTPForward 转发绝对 URI 形式的普通 HTTP 请求
+// 每个连接只处理一个请求，响应后关闭连接
+func (s *socksServer) serveHTTPForward(ctx context.Context, conn *bufferedConn, req *http.Request) {
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		writeHTTPError(conn, http.StatusBadRequest, "")
+		return
+	}
+
+	targetAddr := withDefaultPort(req.URL.Host, "80")
+	slog.Debug("HTTP代理请求", "from", conn.RemoteAddr(), "method", req.Method, "url", req.URL)
+
+	remoteConn, err := s.connect("tcp", targetAddr)
+	if err != nil {
+		slog.Debug("连接目标失败", "target", targetAddr, "error", err)
+		writeHTTPError(conn, dialErrorStatus(err), "")
+		return
+	}
+
+	// 与目标之间的数据通过管道由 bidirectionalCopy 转发，与其他代理连接一样限速、统计流量配额，
+	// 并在上下文取消、空闲超时或达到最长存活时间时关闭
+	upstream, relay := net.Pipe()
+	relayed := make(chan struct{})
+	go func() {
+		defer close(relayed)
+		bidirectionalCopy(ctx, relay, remoteConn, s.limiter)
+	}()
+	defer func() {
+		upstream.Close()
+		remoteConn.Close()
+		<-relayed
+	}()
+
+	removeHopByHopHeaders(req.Header)
+	req.Close = true
+	if err := req.Write(upstream); err != nil {
+		slog.Debug("转发HTTP请求失败", "error", err)
+		writeHTTPError(conn, http.StatusBadGateway, "")
+		return
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(upstream), req)
+	if err != nil {
+		slog.Debug("读取HTTP响应失败", "error", err)
+		writeHTTPError(conn, http.StatusBadGateway, "")
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	resp.Close = true
+	if err := resp.Write(conn); err != nil {
+		slog.Debug("转发HTTP响应失败", "error", err)
+	}
+}
+
+// checkProxyAuth 校验 Proxy-Authorization 基本认证
+func (s *socksServer) checkProxyAuth(req *http.Request) bool {
+	auth := req.Header.Get("Proxy-Authorization")
+	scheme, encoded, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	return ok && s.creds.verify(user, pass)
+}
+
+// removeHopByHopHeaders 删除逐跳头部，包括 Connection 中列出的头部
+func removeHopByHopHeaders(header http.Header) {
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// withDefaultPort 地址未指定端口时补充默认端口
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// dialErrorStatus 根据连接错误返回 HTTP 状态码
+func dialErrorStatus(err error) int {
+	if errors.Is(err, errNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// writeHTTPError 发送 HTTP 错误响应
+func writeHTTPError(conn net.Conn, code int, extraHeaders string) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n%sConnection: close\r\nContent-Length: 0\r\n\r\n",
+		code, http.StatusText(code), extraHeaders)
+}
@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"autossh/internal/config"
+)
+
+// newSlowHTTPServer 启动只返回响应头、响应体每隔 interval 发送一个字节的 HTTP 服务器，测试结束时关闭
+// interval 为 0 时发送响应头后不再发送数据
+func newSlowHTTPServer(t *testing.T, interval time.Duration) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Read(make([]byte, 4096))
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 1000000\r\n\r\n")
+				if interval == 0 {
+					<-done
+					return
+				}
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+						if _, err := conn.Write([]byte("x")); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		listener.Close()
+	})
+	return listener.Addr().String()
+}
+
+func TestHTTPForwardTimeouts(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval time.Duration
+		limits   config.ConnLimits
+	}{
+		{"idle_timeout", 0, config.ConnLimits{IdleTimeout: 200 * time.Millisecond}},
+		{"max_lifetime", 50 * time.Millisecond, config.ConnLimits{MaxLifetime: 300 * time.Millisecond}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			target := newSlowHTTPServer(t, c.interval)
+			s := &socksServer{dial: net.Dial, limiter: newConnLimiter("test", c.limits, false, nil)}
+
+			client, server := net.Pipe()
+			defer client.Close()
+			served := make(chan struct{})
+			go func() {
+				defer close(served)
+				s.serve(context.Background(), server)
+			}()
+
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.WriteString(client, "GET http://"+target+"/ HTTP/1.1\r\nHost: "+target+"\r\n\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			// 响应体未发送完毕，超时后连接应被关闭
+			if _, err := io.Copy(io.Discard, client); err != nil {
+				t.Fatalf("连接未在超时后关闭: %v", err)
+			}
+			<-served
+		})
+	}
+}
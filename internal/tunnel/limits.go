@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"autossh/internal/config"
+)
+
+// connLimiter 单个隧道的连接数、带宽和配额限制
+type connLimiter struct {
+	name     string
+	limits   config.ConnLimits
+	inbound  bool // 客户端来自SSH服务器一侧 (远程转发)，客户端到目标方向为下载
+	upload   *tokenBucket
+	download *tokenBucket
+	global   *Bandwidth // 为 nil 时无全局限制
+	mu       sync.Mutex
+	active   int
+	sources  map[string]int
+	rejected atomic.Int64
+}
+
+// newConnLimiter 创建连接限制器，name 用于日志
+func newConnLimiter(name string, limits config.ConnLimits, inbound bool, global *Bandwidth) *connLimiter {
+	// 配置已在 Validate 中校验
+	upload, _ := config.ParseByteSize(limits.UploadRate)
+	download, _ := config.ParseByteSize(limits.DownloadRate)
+
+	return &connLimiter{
+		name:     name,
+		limits:   limits,
+		inbound:  inbound,
+		upload:   newTokenBucket(upload),
+		download: newTokenBucket(download),
+		global:   global,
+		sources:  make(map[string]int),
+	}
+}
+
+// rateGroups 按隧道类型和名称共用限速令牌桶
+// 端口范围展开的隧道名称相同，upload_rate 和 download_rate 为整个范围的合计速率
+type rateGroups map[[2]string]*connLimiter
+
+// share 同类型同名的隧道已有限速器时改用其令牌桶，否则记录 l
+func (g rateGroups) share(typ, name string, l *connLimiter) {
+	key := [2]string{typ, name}
+	if first, ok := g[key]; ok {
+		l.upload, l.download = first.upload, first.download
+		return
+	}
+	g[key] = l
+}
+
+// acquire 为新连接占用名额，超过限制时记录并返回 false
+// 成功时需在连接结束后调用返回的 release
+func (l *connLimiter) acquire(remote net.Addr) (release func(), ok bool) {
+	source := sourceKey(remote)
+
+	l.mu.Lock()
+	reason := ""
+	switch {
+	case l.limits.MaxConnections > 0 && l.active >= l.limits.MaxConnections:
+		reason = "max_connections"
+	case l.limits.MaxConnectionsPerSource > 0 && source != "" && l.sources[source] >= l.limits.MaxConnectionsPerSource:
+		reason = "max_connections_per_source"
+	case l.quota() != nil && l.quota().action == config.QuotaReject && l.quota().exceeded():
+		reason = "quota"
+	}
+	if reason == "" {
+		l.active++
+		if source != "" {
+			l.sources[source]++
+		}
+	}
+	l.mu.Unlock()
+
+	if reason != "" {
+		slog.Warn("连接数超过限制，已拒绝", "tunnel", l.name, "from", remote, "limit", reason, "rejected", l.rejected.Add(1))
+		return nil, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.active--
+			if source != "" {
+				if l.sources[source]--; l.sources[source] <= 0 {
+					delete(l.sources, source)
+				}
+			}
+		})
+	}, true
+}
+
+// quota 返回全局流量配额，未配置时返回 nil
+func (l *connLimiter) quota() *quota {
+	if l.global == nil {
+		return nil
+	}
+	return l.global.quota
+}
+
+// buckets 返回两个方向的令牌桶: 客户端到目标、目标到客户端，不限速的方向为空
+func (l *connLimiter) buckets() (toTarget, toClient []*tokenBucket) {
+	up, down := appendBucket(nil, l.upload), appendBucket(nil, l.download)
+	if l.global != nil {
+		up = appendBucket(up, l.global.upload)
+		down = appendBucket(down, l.global.download)
+	}
+	if l.inbound {
+		return down, up
+	}
+	return up, down
+}
+
+// appendBucket 添加令牌桶，b 为 nil (不限速) 时不添加
+func appendBucket(list []*tokenBucket, b *tokenBucket) []*tokenBucket {
+	if b == nil {
+		return list
+	}
+	return append(list, b)
+}
+
+// sourceKey 返回来源 IP，无法识别时 (如 Unix 套接字) 返回空字符串
+func sourceKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || host == "" {
+		return ""
+	}
+	return host
+}
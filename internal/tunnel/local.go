@@ -2,11 +2,14 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"autossh/internal/config"
 	"autossh/internal/ssh"
@@ -17,16 +20,21 @@ import (
 type LocalTunnel struct {
 	client   *ssh.Client
 	spec     config.LocalTunnel
+	sources  *sourceACL
+	limiter  *connLimiter
+	tls      *tlsTerminator // 为 nil 时不启用 TLS
 	listener net.Listener
 	mu       sync.Mutex
-	wg       sync.WaitGroup
+	conns    connGroup
 }
 
 // NewLocalTunnel 创建本地转发隧道
-func NewLocalTunnel(client *ssh.Client, spec config.LocalTunnel) *LocalTunnel {
+func NewLocalTunnel(client *ssh.Client, spec config.LocalTunnel, bw *Bandwidth) *LocalTunnel {
 	return &LocalTunnel{
-		client: client,
-		spec:   spec,
+		client:  client,
+		spec:    spec,
+		sources: newSourceACL(spec.Sources),
+		limiter: newConnLimiter(spec.Bind, spec.ConnLimits, false, bw),
 	}
 }
 
@@ -34,16 +42,25 @@ func NewLocalTunnel(client *ssh.Client, spec config.LocalTunnel) *LocalTunnel {
 func (t *LocalTunnel) Start(ctx context.Context) error {
 	t.mu.Lock()
 
+	// 加载 TLS 证书
+	terminator, err := newTLSTerminator(t.spec.TLS)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.tls = terminator
+
 	// 在本地监听
-	listener, err := net.Listen("tcp", t.spec.Bind)
+	listener, err := listen(t.spec.Bind, t.spec.SocketMode, t.spec.SocketOwner)
 	if err != nil {
 		t.mu.Unlock()
 		return fmt.Errorf("本地监听失败 %s: %w", t.spec.Bind, err)
 	}
 	t.listener = listener
+	ctx = t.conns.init(ctx)
 	t.mu.Unlock()
 
-	slog.Info("本地转发已启动", "bind", t.spec.Bind, "target", t.spec.Target)
+	slog.Info("本地转发已启动", "bind", t.spec.Bind, "target", t.spec.Target, "tls", terminator != nil)
 
 	// 接受连接
 	for {
@@ -55,6 +72,9 @@ func (t *LocalTunnel) Start(ctx context.Context) error {
 
 		conn, err := listener.Accept()
 		if err != nil {
+			if listenerClosed(err) {
+				return nil
+			}
 			select {
 			case <-ctx.Done():
 				return nil
@@ -64,42 +84,75 @@ func (t *LocalTunnel) Start(ctx context.Context) error {
 			}
 		}
 
-		t.wg.Add(1)
-		go t.handleConnection(ctx, conn)
+		if !t.sources.permits(conn.RemoteAddr()) {
+			slog.Warn("拒绝来源地址", "from", conn.RemoteAddr(), "bind", t.spec.Bind)
+			conn.Close()
+			continue
+		}
+
+		t.conns.handle(ctx, conn, t.handleConnection)
 	}
 }
 
 // handleConnection 处理连接
 func (t *LocalTunnel) handleConnection(ctx context.Context, localConn net.Conn) {
-	defer t.wg.Done()
-	defer localConn.Close()
+	// 剥离 PROXY 协议头，之后使用头部中的原始客户端地址
+	if t.spec.AcceptProxyProtocol {
+		conn, err := acceptProxyHeader(localConn)
+		if err != nil {
+			slog.Warn("拒绝连接", "from", localConn.RemoteAddr(), "error", err)
+			return
+		}
+		localConn = conn
+	}
+
+	release, ok := t.limiter.acquire(localConn.RemoteAddr())
+	if !ok {
+		return
+	}
+	defer release()
+
+	conn, err := t.tls.server(ctx, localConn)
+	if err != nil {
+		slog.Warn("拒绝连接", "from", localConn.RemoteAddr(), "error", err)
+		return
+	}
+	localConn = conn
 
 	slog.Debug("新的本地转发连接", "from", localConn.RemoteAddr(), "to", t.spec.Target)
 
 	// 通过SSH隧道连接到远程目标
-	remoteConn, err := t.client.Dial("tcp", t.spec.Target)
+	remoteConn, err := t.client.Dial(config.Network(t.spec.Target), t.spec.Target)
 	if err != nil {
 		slog.Warn("连接远程目标失败", "target", t.spec.Target, "error", err)
 		return
 	}
 	defer remoteConn.Close()
 
+	// 向目标发送原始客户端地址
+	if t.spec.ProxyProtocol != "" {
+		if err := writeProxyHeader(remoteConn, t.spec.ProxyProtocol, localConn.RemoteAddr(), localConn.LocalAddr()); err != nil {
+			slog.Warn("发送PROXY协议头失败", "target", t.spec.Target, "error", err)
+			return
+		}
+	}
+
 	// 双向转发数据
-	bidirectionalCopy(ctx, localConn, remoteConn)
+	bidirectionalCopy(ctx, localConn, remoteConn, t.limiter)
 }
 
-// Stop 停止隧道
-func (t *LocalTunnel) Stop() error {
+// Stop 停止隧道，关闭监听后等待现有连接结束，直到 ctx 结束
+// 返回超时后强制关闭的连接数
+func (t *LocalTunnel) Stop(ctx context.Context) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	if t.listener != nil {
 		err := t.listener.Close()
 		t.listener = nil
-		t.wg.Wait()
-		return err
+		return t.conns.stop(ctx, t.String()), err
 	}
-	return nil
+	return 0, nil
 }
 
 // Type 返回隧道类型
@@ -112,37 +165,148 @@ func (t *LocalTunnel) String() string {
 	return fmt.Sprintf("%s -> %s", t.spec.Bind, t.spec.Target)
 }
 
-// bidirectionalCopy 双向复制数据
-func bidirectionalCopy(ctx context.Context, conn1, conn2 net.Conn) {
-	var wg sync.WaitGroup
-	wg.Add(2)
+// Name 返回隧道名称，端口范围展开的隧道名称相同
+func (t *LocalTunnel) Name() string {
+	if t.spec.Name != "" {
+		return t.spec.Name
+	}
+	return t.String()
+}
+
+// Bind 返回监听地址
+func (t *LocalTunnel) Bind() string {
+	return t.spec.Bind
+}
+
+// bidirectionalCopy 双向复制数据，client 为发起连接的一端，target 为连接的目标
+// 两个方向都结束、上下文取消、空闲超时或达到最长存活时间时返回
+// 提前返回时关闭两端，使阻塞在读写上的复制 goroutine 随之结束
+func bidirectionalCopy(ctx context.Context, client, target net.Conn, limiter *connLimiter) {
+	limits := limiter.limits
+	if limits.MaxLifetime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.MaxLifetime)
+		defer cancel()
+	}
+
+	// 返回时中断限速等待
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	copyFunc := func(dst, src net.Conn) {
-		defer wg.Done()
-		_, err := io.Copy(dst, src)
-		if err != nil && !isClosedError(err) {
+	// 记录最后一次收到数据的时间
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	// 每个方向结束时发送一次，两个方向都结束后返回
+	finished := make(chan struct{}, 2)
+
+	copyFunc := func(dst, src net.Conn, buckets []*tokenBucket) {
+		defer func() { finished <- struct{}{} }()
+		// 不需要检测空闲、限速或统计流量的方向直接转发，不限制单次读取的大小
+		var r io.Reader = src
+		if limits.IdleTimeout > 0 || len(buckets) > 0 || limiter.quota() != nil {
+			r = &relayReader{
+				ctx:     ctx,
+				r:       src,
+				last:    &lastActive,
+				buckets: buckets,
+				quota:   limiter.quota(),
+			}
+		}
+		_, err := copyConn(dst, r)
+		if err != nil && !isClosedError(err) && ctx.Err() == nil {
 			slog.Debug("数据转发结束", "error", err)
 		}
-		// 关闭写入端，通知对方数据传输结束
-		if tcpConn, ok := dst.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
+		// 关闭写入端，通知对方数据传输结束 (TCP 发送 FIN，SSH 通道发送 EOF)
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+	}
+
+	toTarget, toClient := limiter.buckets()
+	go copyFunc(target, client, toTarget)
+	go copyFunc(client, target, toClient)
+
+	var idle <-chan time.Time
+	if limits.IdleTimeout > 0 {
+		ticker := time.NewTicker(idleCheckInterval(limits.IdleTimeout))
+		defer ticker.Stop()
+		idle = ticker.C
+	}
+
+	abort := func() {
+		client.Close()
+		target.Close()
+	}
+
+	pending := 2
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				slog.Debug("连接达到最长存活时间，已关闭", "from", client.RemoteAddr(), "lifetime", limits.MaxLifetime)
+			}
+			abort()
+			return
+		case <-finished:
+			pending--
+			if pending == 0 {
+				return
+			}
+		case <-idle:
+			if time.Since(time.Unix(0, lastActive.Load())) >= limits.IdleTimeout {
+				slog.Debug("连接空闲超时，已关闭", "from", client.RemoteAddr(), "idle_timeout", limits.IdleTimeout)
+				abort()
+				return
+			}
 		}
 	}
+}
 
-	go copyFunc(conn1, conn2)
-	go copyFunc(conn2, conn1)
+// idleCheckInterval 空闲检查间隔，取超时的四分之一，不小于 100ms
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	return max(timeout/4, 100*time.Millisecond)
+}
 
-	// 等待两个方向都完成，或者上下文取消
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+// relayReader 转发时记录活动时间、限速并统计流量
+type relayReader struct {
+	ctx     context.Context
+	r       io.Reader
+	last    *atomic.Int64
+	buckets []*tokenBucket
+	quota   *quota
+}
 
-	select {
-	case <-ctx.Done():
-	case <-done:
+// Read 实现 io.Reader
+func (a *relayReader) Read(p []byte) (int, error) {
+	if a.quota != nil {
+		if err := a.quota.wait(a.ctx); err != nil {
+			return 0, err
+		}
+	}
+	if len(a.buckets) > 0 && len(p) > shapeChunkSize {
+		p = p[:shapeChunkSize]
 	}
+
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.last.Store(time.Now().UnixNano())
+		if a.quota != nil {
+			a.quota.add(n)
+		}
+		// 先交付已读取的数据，超出速率的部分在下次读取前等待
+		for _, b := range a.buckets {
+			if werr := b.wait(a.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// closeWriter 支持半关闭的连接 (*net.TCPConn, *net.UnixConn, SSH 通道等)
+type closeWriter interface {
+	CloseWrite() error
 }
 
 // isClosedError 检查是否是连接关闭错误
@@ -2,8 +2,10 @@ package tunnel
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"autossh/internal/config"
@@ -14,25 +16,42 @@ import (
 type Manager struct {
 	client  *ssh.Client
 	cfg     *config.Config
+	bw      *Bandwidth
 	tunnels []Tunnel
+	cancels map[Tunnel]context.CancelFunc // 单独停止隧道 (取消转发) 时使用
 	mu      sync.RWMutex
 	ctx     context.Context
 	cancel  context.CancelFunc
+
+	stateMu   sync.Mutex
+	remotes   []*RemoteTunnel               // 远程转发，顺序与配置相同
+	listening map[*RemoteTunnel]remoteState // 远程转发的实际监听地址
 }
 
 // Tunnel 隧道接口
 type Tunnel interface {
 	Start(ctx context.Context) error
-	Stop() error
+	Stop(ctx context.Context) (int, error) // 关闭监听，等待现有连接结束 (直到 ctx 结束) 后强制关闭，返回强制关闭的连接数
 	Type() string
 	String() string
+	Name() string // 端口范围展开的隧道名称相同
+	Bind() string // 监听地址
+}
+
+// TunnelGroup 同名隧道组 (例如同一端口范围展开的隧道)
+type TunnelGroup struct {
+	Name    string
+	Type    string
+	Tunnels []string
 }
 
 // NewManager 创建隧道管理器
 func NewManager(client *ssh.Client, cfg *config.Config) *Manager {
+	setRelayBufferSize(cfg.Relay.BufferBytes())
 	return &Manager{
 		client: client,
 		cfg:    cfg,
+		bw:     NewBandwidth(cfg.Bandwidth),
 	}
 }
 
@@ -40,45 +59,239 @@ func NewManager(client *ssh.Client, cfg *config.Config) *Manager {
 func (m *Manager) Start() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.start()
+}
 
+// start 启动所有隧道，调用时需持有锁
+func (m *Manager) start() error {
 	m.ctx, m.cancel = context.WithCancel(context.Background())
-	m.tunnels = nil
+	m.cancels = make(map[Tunnel]context.CancelFunc)
+
+	m.stateMu.Lock()
+	m.remotes = nil
+	m.listening = make(map[*RemoteTunnel]remoteState)
+	m.stateMu.Unlock()
+
+	m.tunnels = m.newTunnels(m.cfg.Tunnels)
+	if len(m.tunnels) == 0 {
+		return nil
+	}
+
+	if err := m.startTunnels(m.tunnels); err != nil {
+		// 关闭已启动的隧道，避免重试时监听地址被占用
+		m.stopTunnels(immediate(), m.tunnels)
+		m.tunnels = nil
+		m.cancel()
+		return err
+	}
+	return nil
+}
+
+// Add 添加隧道，已连接时立即启动，重连后继续保留
+func (m *Manager) Add(specs config.TunnelsConfig) error {
+	if err := specs.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkDuplicate(specs); err != nil {
+		return err
+	}
+
+	if m.ctx != nil && m.ctx.Err() == nil {
+		tunnels := m.newTunnels(specs)
+		if err := m.startTunnels(tunnels); err != nil {
+			m.stopTunnels(immediate(), tunnels)
+			m.forgetTunnels(tunnels)
+			return err
+		}
+		m.tunnels = append(m.tunnels, tunnels...)
+	}
+
+	t := &m.cfg.Tunnels
+	t.Local = append(t.Local, specs.Local...)
+	t.Remote = append(t.Remote, specs.Remote...)
+	t.Dynamic = append(t.Dynamic, specs.Dynamic...)
+	t.RemoteDynamic = append(t.RemoteDynamic, specs.RemoteDynamic...)
+	return nil
+}
+
+// Remove 按类型和监听地址移除隧道，返回移除的隧道数量
+// 已建立的连接在 relay.drain_timeout 内结束，超时后关闭
+// 排空期间不持有锁，不阻塞其他隧道的添加、查询和控制命令
+func (m *Manager) Remove(specs config.TunnelsConfig) int {
+	m.mu.Lock()
+
+	binds := make(map[[2]string]bool)
+	for _, key := range specBinds(specs) {
+		binds[key] = true
+	}
+
+	// 从正在运行的隧道中取出
+	var (
+		removed, kept []Tunnel
+		cancels       []context.CancelFunc
+	)
+	for _, t := range m.tunnels {
+		if !binds[[2]string{t.Type(), t.Bind()}] {
+			kept = append(kept, t)
+			continue
+		}
+		removed = append(removed, t)
+		if cancel, ok := m.cancels[t]; ok {
+			cancels = append(cancels, cancel)
+			delete(m.cancels, t)
+		}
+	}
+	m.tunnels = kept
+	drain := m.cfg.Relay.DrainTimeout
+
+	// 从配置中删除，重连后不再建立
+	t := &m.cfg.Tunnels
+	count := 0
+	t.Local = removeSpecs(t.Local, func(s config.LocalTunnel) bool { return binds[[2]string{"local", s.Bind}] }, &count)
+	t.Remote = removeSpecs(t.Remote, func(s config.RemoteTunnel) bool { return binds[[2]string{"remote", s.Bind}] }, &count)
+	t.Dynamic = removeSpecs(t.Dynamic, func(s config.DynamicTunnel) bool { return binds[[2]string{"dynamic", s.Bind}] }, &count)
+	t.RemoteDynamic = removeSpecs(t.RemoteDynamic, func(s config.RemoteDynamicTunnel) bool {
+		return binds[[2]string{"remote-dynamic", s.Bind}]
+	}, &count)
+	m.mu.Unlock()
+
+	// 停止取出的隧道
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if cut := drainTunnels(ctx, removed); cut > 0 {
+		slog.Warn("取消转发时强制关闭了未结束的连接", "count", cut)
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	m.forgetTunnels(removed)
+	return count
+}
+
+// removeSpecs 删除满足条件的配置项并累计删除数量
+func removeSpecs[T any](specs []T, match func(T) bool, count *int) []T {
+	kept := specs[:0]
+	for _, spec := range specs {
+		if match(spec) {
+			*count++
+			continue
+		}
+		kept = append(kept, spec)
+	}
+	return kept
+}
+
+// checkDuplicate 检查新隧道的监听地址是否与已有隧道重复，调用时需持有锁
+func (m *Manager) checkDuplicate(specs config.TunnelsConfig) error {
+	seen := make(map[[2]string]bool)
+	for _, key := range specBinds(m.cfg.Tunnels) {
+		seen[key] = true
+	}
+	for _, key := range specBinds(specs) {
+		if seen[key] {
+			return fmt.Errorf("隧道已存在: %s %s", key[0], key[1])
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// specBinds 返回配置中各隧道的类型和监听地址
+func specBinds(specs config.TunnelsConfig) [][2]string {
+	var keys [][2]string
+	for _, t := range specs.Local {
+		keys = append(keys, [2]string{"local", t.Bind})
+	}
+	for _, t := range specs.Remote {
+		keys = append(keys, [2]string{"remote", t.Bind})
+	}
+	for _, t := range specs.Dynamic {
+		keys = append(keys, [2]string{"dynamic", t.Bind})
+	}
+	for _, t := range specs.RemoteDynamic {
+		keys = append(keys, [2]string{"remote-dynamic", t.Bind})
+	}
+	return keys
+}
+
+// newTunnels 根据配置创建隧道，调用时需持有锁
+func (m *Manager) newTunnels(specs config.TunnelsConfig) []Tunnel {
+	var tunnels []Tunnel
+
+	// 同名隧道共用限速
+	rates := make(rateGroups)
 
 	// 创建本地转发隧道
-	for _, spec := range m.cfg.Tunnels.Local {
-		tunnel := NewLocalTunnel(m.client, spec)
-		m.tunnels = append(m.tunnels, tunnel)
+	for _, spec := range specs.Local {
+		tunnel := NewLocalTunnel(m.client, spec, m.bw)
+		rates.share(tunnel.Type(), tunnel.Name(), tunnel.limiter)
+		tunnels = append(tunnels, tunnel)
 	}
 
-	// 创建远程转发隧道
-	for _, spec := range m.cfg.Tunnels.Remote {
-		tunnel := NewRemoteTunnel(m.client, spec)
-		m.tunnels = append(m.tunnels, tunnel)
+	// 创建远程转发隧道，记录实际监听地址
+	for _, spec := range specs.Remote {
+		tunnel := NewRemoteTunnel(m.client, spec, m.bw)
+		rates.share(tunnel.Type(), tunnel.Name(), tunnel.limiter)
+		tunnel.onListen = func(addr string, port int) {
+			m.remoteListening(tunnel, remoteState{
+				Name:   tunnel.Name(),
+				Bind:   spec.Bind,
+				Addr:   addr,
+				Port:   port,
+				Target: spec.Target,
+			}, spec.Hook)
+		}
+		m.stateMu.Lock()
+		m.remotes = append(m.remotes, tunnel)
+		m.stateMu.Unlock()
+		tunnels = append(tunnels, tunnel)
 	}
 
 	// 创建动态转发隧道
-	for _, spec := range m.cfg.Tunnels.Dynamic {
-		tunnel := NewDynamicTunnel(m.client, spec)
-		m.tunnels = append(m.tunnels, tunnel)
+	for _, spec := range specs.Dynamic {
+		tunnels = append(tunnels, NewDynamicTunnel(m.client, spec, m.bw))
 	}
 
-	if len(m.tunnels) == 0 {
+	// 创建反向动态转发隧道
+	for _, spec := range specs.RemoteDynamic {
+		tunnels = append(tunnels, NewRemoteDynamicTunnel(m.client, spec, m.bw))
+	}
+
+	return tunnels
+}
+
+// startTunnels 启动隧道并等待一小段时间收集初始化错误，调用时需持有锁
+func (m *Manager) startTunnels(tunnels []Tunnel) error {
+	if len(tunnels) == 0 {
 		return nil
 	}
 
 	// 启动所有隧道，收集初始化错误
-	errChan := make(chan error, len(m.tunnels))
+	errChan := make(chan error, len(tunnels))
 
-	for _, t := range m.tunnels {
-		slog.Info("启动隧道", "type", t.Type(), "spec", t.String())
+	for _, g := range groupTunnels(tunnels) {
+		if len(g.Tunnels) > 1 {
+			slog.Info("启动隧道组", "type", g.Type, "name", g.Name, "count", len(g.Tunnels))
+		} else {
+			slog.Info("启动隧道", "type", g.Type, "spec", g.Tunnels[0])
+		}
+	}
+
+	for _, t := range tunnels {
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.cancels[t] = cancel
 		go func(tunnel Tunnel) {
-			if err := tunnel.Start(m.ctx); err != nil {
+			if err := tunnel.Start(ctx); err != nil {
 				// 只有在 context 未取消时才记录错误
 				select {
-				case <-m.ctx.Done():
+				case <-ctx.Done():
 					// context 已取消，这是正常停止
 				default:
-					slog.Error("隧道启动失败", "type", tunnel.Type(), "spec", tunnel.String(), "error", err)
+					slog.Error("隧道启动失败", "type", tunnel.Type(), "name", tunnel.Name(), "spec", tunnel.String(), "error", err)
 					select {
 					case errChan <- err:
 					default:
@@ -97,9 +310,6 @@ func (m *Manager) Start() error {
 	select {
 	case err := <-errChan:
 		// 有隧道启动失败
-		if m.cancel != nil {
-			m.cancel()
-		}
 		return err
 	case <-timeoutCtx.Done():
 		// 100ms 内没有错误，认为启动成功
@@ -107,29 +317,130 @@ func (m *Manager) Start() error {
 	}
 }
 
-// Stop 停止所有隧道
+// stopTunnels 停止隧道并取消其上下文，返回强制关闭的连接数，调用时需持有锁
+func (m *Manager) stopTunnels(ctx context.Context, tunnels []Tunnel) int {
+	cut := drainTunnels(ctx, tunnels)
+	for _, t := range tunnels {
+		if cancel, ok := m.cancels[t]; ok {
+			cancel()
+			delete(m.cancels, t)
+		}
+	}
+	return cut
+}
+
+// drainTunnels 同时停止隧道，返回强制关闭的连接数
+// 所有隧道先同时停止接受新连接，再同时排空，ctx 结束后强制关闭，总耗时不超过 ctx 的期限加上强制关闭的等待时间
+func drainTunnels(ctx context.Context, tunnels []Tunnel) int {
+	var (
+		wg  sync.WaitGroup
+		cut atomic.Int64
+	)
+	for _, t := range tunnels {
+		slog.Debug("停止隧道", "type", t.Type(), "spec", t.String())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := t.Stop(ctx)
+			if err != nil {
+				slog.Warn("停止隧道失败", "type", t.Type(), "error", err)
+			}
+			cut.Add(int64(n))
+		}()
+	}
+	wg.Wait()
+	return int(cut.Load())
+}
+
+// immediate 返回已结束的上下文，用于不等待现有连接直接停止隧道
+func immediate() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// forgetTunnels 清除单独停止的远程转发的状态
+func (m *Manager) forgetTunnels(tunnels []Tunnel) {
+	for _, t := range tunnels {
+		if rt, ok := t.(*RemoteTunnel); ok {
+			m.remoteStopped(rt)
+		}
+	}
+}
+
+// Stop 立即停止所有隧道，关闭所有连接 (用于连接断开)
 func (m *Manager) Stop() {
+	m.stop(context.Background(), 0)
+}
+
+// Shutdown 停止所有隧道，已建立的连接在 shutdown.grace_period 内结束，超时或 ctx 取消后强制关闭
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.stop(ctx, m.cfg.Shutdown.GracePeriod)
+}
+
+// stop 停止所有隧道，最多等待 drain
+func (m *Manager) stop(parent context.Context, drain time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if drain > 0 && len(m.tunnels) > 0 {
+		slog.Info("停止接受新连接，等待现有连接结束", "grace_period", drain)
+	}
+	ctx, cancel := context.WithTimeout(parent, drain)
+	defer cancel()
+	cut := m.stopTunnels(ctx, m.tunnels)
+	if drain > 0 && cut > 0 {
+		slog.Warn("强制关闭未结束的连接", "count", cut)
+	}
+	m.clearState()
 	if m.cancel != nil {
 		m.cancel()
 	}
 
-	for _, t := range m.tunnels {
-		slog.Debug("停止隧道", "type", t.Type(), "spec", t.String())
-		if err := t.Stop(); err != nil {
-			slog.Warn("停止隧道失败", "type", t.Type(), "error", err)
-		}
-	}
-
 	m.tunnels = nil
+	m.cancels = nil
+
+	// 保存流量配额用量
+	m.bw.Save()
 }
 
-// Restart 重启所有隧道
+// Restart 重启所有隧道，重新读取认证用户文件、路由规则和证书，现有连接会断开
+// 隧道未运行 (连接断开等待重连) 时不执行，重连后按配置启动
 func (m *Manager) Restart() error {
-	m.Stop()
-	return m.Start()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx == nil || m.ctx.Err() != nil {
+		return nil
+	}
+	m.stopTunnels(immediate(), m.tunnels)
+	m.clearState()
+	m.cancel()
+	return m.start()
+}
+
+// Groups 返回按名称分组的隧道，保持配置中的顺序
+func (m *Manager) Groups() []TunnelGroup {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return groupTunnels(m.tunnels)
+}
+
+// groupTunnels 按类型和名称对隧道分组
+func groupTunnels(tunnels []Tunnel) []TunnelGroup {
+	var groups []TunnelGroup
+	index := make(map[[2]string]int)
+	for _, t := range tunnels {
+		key := [2]string{t.Type(), t.Name()}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, TunnelGroup{Name: t.Name(), Type: t.Type()})
+		}
+		groups[i].Tunnels = append(groups[i].Tunnels, t.String())
+	}
+	return groups
 }
 
 // TunnelCount 返回隧道数量
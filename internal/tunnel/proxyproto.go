@@ -0,0 +1,236 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"autossh/internal/config"
+)
+
+const (
+	// proxyHeaderTimeout 读取 PROXY 协议头的超时
+	proxyHeaderTimeout = 10 * time.Second
+
+	// proxyV1MaxLen v1 头部的最大长度 (包括 CRLF)
+	proxyV1MaxLen = 107
+
+	// v2 命令和地址族
+	proxyV2Local  = 0x20
+	proxyV2Proxy  = 0x21
+	proxyV2Unspec = 0x00
+	proxyV2TCP4   = 0x11
+	proxyV2TCP6   = 0x21
+)
+
+// proxyV2Signature v2 头部签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// writeProxyHeader 向 w 写入 PROXY 协议头，src 为原始客户端地址，dst 为客户端连接的地址
+// 非 TCP 地址 (如 Unix 套接字) 写入 UNKNOWN (v1) 或 UNSPEC (v2)
+func writeProxyHeader(w io.Writer, version string, src, dst net.Addr) error {
+	srcAP, srcOK := tcpAddrPort(src)
+	dstAP, dstOK := tcpAddrPort(dst)
+	known := srcOK && dstOK
+
+	// 地址族不一致时统一使用 IPv6
+	ipv4 := known && srcAP.Addr().Is4() && dstAP.Addr().Is4()
+	if known && !ipv4 {
+		srcAP = netip.AddrPortFrom(netip.AddrFrom16(srcAP.Addr().As16()), srcAP.Port())
+		dstAP = netip.AddrPortFrom(netip.AddrFrom16(dstAP.Addr().As16()), dstAP.Port())
+	}
+
+	var header []byte
+	switch version {
+	case config.ProxyProtocolV1:
+		switch {
+		case !known:
+			header = []byte("PROXY UNKNOWN\r\n")
+		case ipv4:
+			header = fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", srcAP.Addr(), dstAP.Addr(), srcAP.Port(), dstAP.Port())
+		default:
+			header = fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", srcAP.Addr(), dstAP.Addr(), srcAP.Port(), dstAP.Port())
+		}
+	case config.ProxyProtocolV2:
+		header = append(header, proxyV2Signature...)
+		switch {
+		case !known:
+			header = append(header, proxyV2Proxy, proxyV2Unspec, 0, 0)
+		case ipv4:
+			header = append(header, proxyV2Proxy, proxyV2TCP4, 0, 12)
+		default:
+			header = append(header, proxyV2Proxy, proxyV2TCP6, 0, 36)
+		}
+		if known {
+			header = append(header, srcAP.Addr().AsSlice()...)
+			header = append(header, dstAP.Addr().AsSlice()...)
+			header = binary.BigEndian.AppendUint16(header, srcAP.Port())
+			header = binary.BigEndian.AppendUint16(header, dstAP.Port())
+		}
+	default:
+		return fmt.Errorf("无效的 PROXY 协议版本: %s", version)
+	}
+
+	_, err := w.Write(header)
+	return err
+}
+
+// tcpAddrPort 将 TCP 地址转换为 netip.AddrPort，IPv4 映射地址转换为 IPv4
+func tcpAddrPort(addr net.Addr) (netip.AddrPort, bool) {
+	if addr == nil || addr.Network() != "tcp" {
+		return netip.AddrPort{}, false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
+
+// proxiedConn 剥离 PROXY 协议头后的连接，地址为头部中声明的原始地址
+type proxiedConn struct {
+	*bufferedConn
+	src net.Addr
+	dst net.Addr
+}
+
+// RemoteAddr 返回原始客户端地址
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.src
+}
+
+// LocalAddr 返回客户端原本连接的地址
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.dst
+}
+
+// acceptProxyHeader 读取并剥离客户端发送的 PROXY 协议头 (v1 或 v2)
+// 头部缺失或格式错误时返回错误，LOCAL 命令和未知地址族保留连接的真实地址
+func acceptProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	bc := newBufferedConn(conn)
+	pc := &proxiedConn{bufferedConn: bc, src: conn.RemoteAddr(), dst: conn.LocalAddr()}
+
+	prefix, err := bc.r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("读取 PROXY 协议头失败: %w", err)
+	}
+
+	if bytes.Equal(prefix, proxyV2Signature) {
+		err = readProxyV2(bc, pc)
+	} else {
+		err = readProxyV1(bc, pc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// readProxyV1 解析 v1 文本头部
+// PROXY TCP4|TCP6 SRC DST SPORT DPORT\r\n 或 PROXY UNKNOWN ...\r\n
+func readProxyV1(bc *bufferedConn, pc *proxiedConn) error {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := bc.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("读取 PROXY 协议头失败: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) || !bytes.HasPrefix(line, []byte("PROXY ")) {
+		return fmt.Errorf("缺少 PROXY 协议头")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("无效的 PROXY 协议头: %q", line)
+	}
+
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	pc.src, pc.dst = src, dst
+	return nil
+}
+
+// parseProxyV1Addr 解析 v1 头部中的地址和端口
+func parseProxyV1Addr(host, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 PROXY 协议地址: %s", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 PROXY 协议端口: %s", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readProxyV2 解析 v2 二进制头部
+// SIG(12) | VER_CMD | FAM | LEN(2) | ADDR... [| TLV...]
+func readProxyV2(bc *bufferedConn, pc *proxiedConn) error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(bc, header); err != nil {
+		return fmt.Errorf("读取 PROXY 协议头失败: %w", err)
+	}
+	if header[12]&0xF0 != 0x20 {
+		return fmt.Errorf("不支持的 PROXY 协议版本: %d", header[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(bc, body); err != nil {
+		return fmt.Errorf("读取 PROXY 协议头失败: %w", err)
+	}
+
+	switch header[12] {
+	case proxyV2Local:
+		// 健康检查等由代理自身发起的连接，使用真实地址
+		return nil
+	case proxyV2Proxy:
+	default:
+		return fmt.Errorf("不支持的 PROXY 协议命令: %d", header[12]&0x0F)
+	}
+
+	var ipLen int
+	switch header[13] {
+	case proxyV2TCP4:
+		ipLen = 4
+	case proxyV2TCP6:
+		ipLen = 16
+	default:
+		// UDP、Unix 套接字及未知地址族不提供可用的 TCP 地址
+		return nil
+	}
+	if len(body) < ipLen*2+4 {
+		return fmt.Errorf("PROXY 协议头地址长度无效: %d", len(body))
+	}
+
+	srcIP, _ := netip.AddrFromSlice(body[:ipLen])
+	dstIP, _ := netip.AddrFromSlice(body[ipLen : ipLen*2])
+	srcPort := binary.BigEndian.Uint16(body[ipLen*2:])
+	dstPort := binary.BigEndian.Uint16(body[ipLen*2+2:])
+
+	pc.src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort))
+	pc.dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort))
+	return nil
+}
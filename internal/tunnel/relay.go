@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"autossh/internal/config"
+)
+
+// bufferPool 转发缓冲区池，所有连接共用
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool 创建指定大小的缓冲区池
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// get 取出一个缓冲区
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// put 归还缓冲区
+func (p *bufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
+
+// relayBuffers 当前使用的缓冲区池
+var relayBuffers atomic.Pointer[bufferPool]
+
+func init() {
+	relayBuffers.Store(newBufferPool(config.DefaultRelayBufferSize))
+}
+
+// setRelayBufferSize 设置转发缓冲区大小，大小变化时替换缓冲区池
+// 正在转发的连接继续使用旧的缓冲区，归还后由 GC 回收
+func setRelayBufferSize(size int) {
+	if size <= 0 {
+		size = config.DefaultRelayBufferSize
+	}
+	if relayBuffers.Load().size != size {
+		relayBuffers.Store(newBufferPool(size))
+	}
+}
+
+// copyConn 从 src 复制数据到 dst，直到 src 返回 EOF 或出错
+// 两端都是内核套接字时由 io.Copy 使用 splice 在内核中直接复制，
+// 否则 (SSH 通道、TLS 等) 使用缓冲区池中的缓冲区，避免每个连接分配新的缓冲区
+func copyConn(dst io.Writer, src io.Reader) (int64, error) {
+	if spliceable(dst, src) {
+		return io.Copy(dst, src)
+	}
+
+	pool := relayBuffers.Load()
+	buf := pool.get()
+	defer pool.put(buf)
+	return copyBuffer(dst, src, *buf)
+}
+
+// spliceable 检查两端是否都是支持内核直接复制的套接字
+func spliceable(dst io.Writer, src io.Reader) bool {
+	switch src.(type) {
+	case *net.TCPConn, *net.UnixConn:
+	default:
+		return false
+	}
+	_, ok := dst.(*net.TCPConn)
+	return ok
+}
+
+// copyBuffer 使用给定的缓冲区复制数据
+// 与 io.CopyBuffer 不同，不使用 ReaderFrom/WriterTo，保证只使用传入的缓冲区
+func copyBuffer(dst io.Writer, src io.Reader, buf []byte) (written int64, err error) {
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return written, nil
+			}
+			return written, rerr
+		}
+	}
+}
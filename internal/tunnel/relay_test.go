@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"autossh/internal/config"
+)
+
+// relayPayload 每次复制的数据量
+const relayPayload = 256 << 10
+
+// channelConn 隐藏底层连接的具体类型，模拟 SSH 通道一端 (无法使用 splice)
+type channelConn struct {
+	net.Conn
+}
+
+// connPair 返回一对相互连接的连接
+type connPair func(b *testing.B) (net.Conn, net.Conn)
+
+// pipePair 内存管道
+func pipePair(b *testing.B) (net.Conn, net.Conn) {
+	c1, c2 := net.Pipe()
+	return c1, c2
+}
+
+// tcpPair 本地回环 TCP 连接
+func tcpPair(b *testing.B) (net.Conn, net.Conn) {
+	b.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			conn = nil
+		}
+		accepted <- conn
+	}()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	c2 := <-accepted
+	if c2 == nil {
+		b.Fatal("接受连接失败")
+	}
+	return channelConn{c1}, channelConn{c2}
+}
+
+var relayPairs = []struct {
+	name string
+	pair connPair
+}{
+	{"pipe", pipePair},
+	{"tcp", tcpPair},
+}
+
+// payloadChunk 写入数据使用的缓冲区，只读，所有写入方共用
+var payloadChunk = make([]byte, 16<<10)
+
+// writePayload 写入 n 字节数据
+func writePayload(w io.Writer, n int) {
+	for n > 0 {
+		size := min(n, len(payloadChunk))
+		if _, err := w.Write(payloadChunk[:size]); err != nil {
+			return
+		}
+		n -= size
+	}
+}
+
+// BenchmarkCopyConn 比较从缓冲区池取缓冲区与每次复制分配新缓冲区 (改用缓冲区池之前的做法)
+// 每次迭代相当于一个连接的一个方向
+func BenchmarkCopyConn(b *testing.B) {
+	copies := []struct {
+		name string
+		copy func(dst io.Writer, src io.Reader) (int64, error)
+	}{
+		{"pooled", copyConn},
+		{"alloc", func(dst io.Writer, src io.Reader) (int64, error) {
+			return copyBuffer(dst, src, make([]byte, config.DefaultRelayBufferSize))
+		}},
+	}
+
+	for _, p := range relayPairs {
+		for _, c := range copies {
+			b.Run(p.name+"/"+c.name, func(b *testing.B) {
+				// writer -> src ==copy==> dst -> reader
+				writer, src := p.pair(b)
+				dst, reader := p.pair(b)
+				defer func() {
+					for _, conn := range []net.Conn{writer, src, dst, reader} {
+						conn.Close()
+					}
+				}()
+
+				b.SetBytes(relayPayload)
+				b.ReportAllocs()
+				for b.Loop() {
+					go writePayload(writer, relayPayload)
+					done := make(chan error, 1)
+					go func() {
+						_, err := io.CopyN(io.Discard, reader, relayPayload)
+						done <- err
+					}()
+					if _, err := c.copy(dst, io.LimitReader(src, relayPayload)); err != nil {
+						b.Fatal(err)
+					}
+					if err := <-done; err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBidirectionalCopy 完整转发一个连接: 客户端发送数据，目标原样返回后关闭
+func BenchmarkBidirectionalCopy(b *testing.B) {
+	limiter := newConnLimiter("bench", config.ConnLimits{}, false, nil)
+
+	for _, p := range relayPairs {
+		b.Run(p.name, func(b *testing.B) {
+			// 目标使用固定的缓冲区，只统计转发本身的分配
+			echoBuf := make([]byte, config.DefaultRelayBufferSize)
+			b.SetBytes(2 * relayPayload)
+			b.ReportAllocs()
+			for b.Loop() {
+				b.StopTimer()
+				user, client := p.pair(b)
+				target, server := p.pair(b)
+				b.StartTimer()
+
+				// 目标: 读取全部数据后原样返回并关闭
+				go func() {
+					defer server.Close()
+					copyBuffer(server, io.LimitReader(server, relayPayload), echoBuf)
+				}()
+				go writePayload(user, relayPayload)
+
+				relayed := make(chan struct{})
+				go func() {
+					bidirectionalCopy(context.Background(), client, target, limiter)
+					close(relayed)
+				}()
+
+				if _, err := io.CopyN(io.Discard, user, relayPayload); err != nil {
+					b.Fatal(err)
+				}
+				user.Close()
+				<-relayed
+				client.Close()
+				target.Close()
+			}
+		})
+	}
+}
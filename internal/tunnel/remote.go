@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"autossh/internal/config"
@@ -16,16 +18,19 @@ import (
 type RemoteTunnel struct {
 	client   *ssh.Client
 	spec     config.RemoteTunnel
+	limiter  *connLimiter
 	listener net.Listener
+	onListen func(addr string, port int) // 远程监听建立后调用，port 为实际端口 (Unix 套接字为 0)
 	mu       sync.Mutex
-	wg       sync.WaitGroup
+	conns    connGroup
 }
 
 // NewRemoteTunnel 创建远程转发隧道
-func NewRemoteTunnel(client *ssh.Client, spec config.RemoteTunnel) *RemoteTunnel {
+func NewRemoteTunnel(client *ssh.Client, spec config.RemoteTunnel, bw *Bandwidth) *RemoteTunnel {
 	return &RemoteTunnel{
-		client: client,
-		spec:   spec,
+		client:  client,
+		spec:    spec,
+		limiter: newConnLimiter(spec.Bind, spec.ConnLimits, true, bw),
 	}
 }
 
@@ -33,16 +38,32 @@ func NewRemoteTunnel(client *ssh.Client, spec config.RemoteTunnel) *RemoteTunnel
 func (t *RemoteTunnel) Start(ctx context.Context) error {
 	t.mu.Lock()
 
+	// 清理远程残留的套接字文件
+	if t.spec.UnlinkStale && config.IsUnixSocket(t.spec.Bind) {
+		if err := t.client.Run("rm -f " + shellQuote(t.spec.Bind)); err != nil {
+			slog.Warn("删除远程套接字文件失败", "path", t.spec.Bind, "error", err)
+		}
+	}
+
 	// 在远程服务器上监听
-	listener, err := t.client.Listen("tcp", t.spec.Bind)
+	listener, err := t.client.Listen(config.Network(t.spec.Bind), t.spec.Bind)
 	if err != nil {
 		t.mu.Unlock()
 		return fmt.Errorf("远程监听失败 %s: %w", t.spec.Bind, err)
 	}
 	t.listener = listener
+	ctx = t.conns.init(ctx)
 	t.mu.Unlock()
 
-	slog.Info("远程转发已启动", "bind", t.spec.Bind, "target", t.spec.Target)
+	addr, port := t.boundAddr(listener)
+	if addr != t.spec.Bind {
+		slog.Info("远程转发已启动", "bind", t.spec.Bind, "target", t.spec.Target, "allocated", addr)
+	} else {
+		slog.Info("远程转发已启动", "bind", t.spec.Bind, "target", t.spec.Target)
+	}
+	if t.onListen != nil {
+		t.onListen(addr, port)
+	}
 
 	// 接受连接
 	for {
@@ -54,6 +75,9 @@ func (t *RemoteTunnel) Start(ctx context.Context) error {
 
 		remoteConn, err := listener.Accept()
 		if err != nil {
+			if listenerClosed(err) {
+				return nil
+			}
 			select {
 			case <-ctx.Done():
 				return nil
@@ -63,42 +87,67 @@ func (t *RemoteTunnel) Start(ctx context.Context) error {
 			}
 		}
 
-		t.wg.Add(1)
-		go t.handleConnection(ctx, remoteConn)
+		t.conns.handle(ctx, remoteConn, t.handleConnection)
+	}
+}
+
+// boundAddr 返回远程实际监听的地址和端口
+// 配置端口为 0 时，端口为服务器在 tcpip-forward 响应中分配的端口
+func (t *RemoteTunnel) boundAddr(listener net.Listener) (string, int) {
+	if config.IsUnixSocket(t.spec.Bind) {
+		return t.spec.Bind, 0
+	}
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	host, _, err := net.SplitHostPort(t.spec.Bind)
+	if !ok || err != nil {
+		return t.spec.Bind, 0
 	}
+	return net.JoinHostPort(host, strconv.Itoa(tcpAddr.Port)), tcpAddr.Port
 }
 
 // handleConnection 处理连接
 func (t *RemoteTunnel) handleConnection(ctx context.Context, remoteConn net.Conn) {
-	defer t.wg.Done()
-	defer remoteConn.Close()
+	release, ok := t.limiter.acquire(remoteConn.RemoteAddr())
+	if !ok {
+		return
+	}
+	defer release()
 
 	slog.Debug("新的远程转发连接", "from", remoteConn.RemoteAddr(), "to", t.spec.Target)
 
 	// 连接到本地目标
-	localConn, err := net.Dial("tcp", t.spec.Target)
+	var dialer net.Dialer
+	localConn, err := dialer.DialContext(ctx, config.Network(t.spec.Target), t.spec.Target)
 	if err != nil {
 		slog.Warn("连接本地目标失败", "target", t.spec.Target, "error", err)
 		return
 	}
 	defer localConn.Close()
 
+	// 向本地目标发送原始客户端地址
+	if t.spec.ProxyProtocol != "" {
+		if err := writeProxyHeader(localConn, t.spec.ProxyProtocol, remoteConn.RemoteAddr(), remoteConn.LocalAddr()); err != nil {
+			slog.Warn("发送PROXY协议头失败", "target", t.spec.Target, "error", err)
+			return
+		}
+	}
+
 	// 双向转发数据
-	bidirectionalCopy(ctx, remoteConn, localConn)
+	bidirectionalCopy(ctx, remoteConn, localConn, t.limiter)
 }
 
-// Stop 停止隧道
-func (t *RemoteTunnel) Stop() error {
+// Stop 停止隧道，关闭监听后等待现有连接结束，直到 ctx 结束
+// 返回超时后强制关闭的连接数
+func (t *RemoteTunnel) Stop(ctx context.Context) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	if t.listener != nil {
 		err := t.listener.Close()
 		t.listener = nil
-		t.wg.Wait()
-		return err
+		return t.conns.stop(ctx, t.String()), err
 	}
-	return nil
+	return 0, nil
 }
 
 // Type 返回隧道类型
@@ -111,3 +160,20 @@ func (t *RemoteTunnel) String() string {
 	return fmt.Sprintf("%s -> %s", t.spec.Bind, t.spec.Target)
 }
 
+// Name 返回隧道名称，端口范围展开的隧道名称相同
+func (t *RemoteTunnel) Name() string {
+	if t.spec.Name != "" {
+		return t.spec.Name
+	}
+	return t.String()
+}
+
+// Bind 返回监听地址
+func (t *RemoteTunnel) Bind() string {
+	return t.spec.Bind
+}
+
+// shellQuote 使用单引号转义 shell 参数
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
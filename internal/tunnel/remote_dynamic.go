@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+)
+
+// RemoteDynamicTunnel 反向动态端口转发隧道 (-R port)
+// 在远程服务器上提供 SOCKS5 代理，目标地址由本地连接
+type RemoteDynamicTunnel struct {
+	client   *ssh.Client
+	spec     config.RemoteDynamicTunnel
+	acl      *destACL
+	socks    *socksServer
+	limiter  *connLimiter
+	listener net.Listener
+	mu       sync.Mutex
+	conns    connGroup
+}
+
+// NewRemoteDynamicTunnel 创建反向动态转发隧道
+func NewRemoteDynamicTunnel(client *ssh.Client, spec config.RemoteDynamicTunnel, bw *Bandwidth) *RemoteDynamicTunnel {
+	t := &RemoteDynamicTunnel{
+		client:  client,
+		spec:    spec,
+		limiter: newConnLimiter(spec.Bind, spec.ConnLimits, true, bw),
+	}
+	// 配置已在 Validate 中校验
+	t.acl, _ = newDestACL(spec.Destinations)
+	// 访问控制在 dial 中按本地解析的地址检查，socksServer 不再检查
+	t.socks = &socksServer{dial: t.dial, limiter: t.limiter}
+	return t
+}
+
+// Start 启动隧道
+func (t *RemoteDynamicTunnel) Start(ctx context.Context) error {
+	t.mu.Lock()
+
+	// 在远程服务器上监听
+	listener, err := t.client.Listen("tcp", t.spec.Bind)
+	if err != nil {
+		t.mu.Unlock()
+		return fmt.Errorf("远程SOCKS5监听失败 %s: %w", t.spec.Bind, err)
+	}
+	t.listener = listener
+	ctx = t.conns.init(ctx)
+	t.mu.Unlock()
+
+	slog.Info("远程SOCKS5代理已启动", "bind", t.spec.Bind, "addr", listener.Addr(), "allow", t.spec.Destinations.Allow)
+
+	// 接受连接
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if listenerClosed(err) {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				slog.Warn("接受远程SOCKS5连接失败", "error", err)
+				continue
+			}
+		}
+
+		t.conns.handle(ctx, conn, t.handleConnection)
+	}
+}
+
+// handleConnection 处理 SOCKS5 连接
+func (t *RemoteDynamicTunnel) handleConnection(ctx context.Context, conn net.Conn) {
+	release, ok := t.limiter.acquire(conn.RemoteAddr())
+	if !ok {
+		return
+	}
+	defer release()
+
+	t.socks.serve(ctx, conn)
+}
+
+// dial 在本地连接目标地址，只允许访问访问控制允许的目标
+// 域名先在本地解析，依次连接解析出的允许访问的地址，返回最后一个错误
+func (t *RemoteDynamicTunnel) dial(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := fmt.Errorf("%w: %s", errNotAllowed, address)
+	var d net.Dialer
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if !t.acl.permitsResolved(address, addr) {
+			continue
+		}
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Stop 停止隧道，关闭监听后等待现有连接结束，直到 ctx 结束
+// 返回超时后强制关闭的连接数
+func (t *RemoteDynamicTunnel) Stop(ctx context.Context) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.listener != nil {
+		err := t.listener.Close()
+		t.listener = nil
+		return t.conns.stop(ctx, t.String()), err
+	}
+	return 0, nil
+}
+
+// Type 返回隧道类型
+func (t *RemoteDynamicTunnel) Type() string {
+	return "remote-dynamic"
+}
+
+// String 返回隧道描述
+func (t *RemoteDynamicTunnel) String() string {
+	return fmt.Sprintf("SOCKS5 remote %s", t.spec.Bind)
+}
+
+// Name 返回隧道名称
+func (t *RemoteDynamicTunnel) Name() string {
+	return t.String()
+}
+
+// Bind 返回监听地址
+func (t *RemoteDynamicTunnel) Bind() string {
+	return t.spec.Bind
+}
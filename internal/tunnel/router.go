@@ -0,0 +1,241 @@
+package tunnel
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"autossh/internal/config"
+)
+
+// rulesCheckInterval 检查规则文件是否修改的最小间隔
+const rulesCheckInterval = time.Second
+
+// router 动态转发的规则路由
+// 按规则决定目标通过SSH隧道、本地直连、上游代理连接或被拒绝
+type router struct {
+	cfg       config.RoutingConfig
+	tunnel    func(network, address string) (net.Conn, error)
+	upstreams map[string]*url.URL
+
+	mu        sync.Mutex
+	inline    []routeRule
+	fileRules []routeRule
+	fileMod   time.Time
+	lastCheck time.Time
+}
+
+// routeRule 编译后的路由规则
+type routeRule struct {
+	domains  []string
+	globs    []string
+	prefixes []netip.Prefix
+	ports    [][2]int
+	action   string
+	upstream string
+}
+
+// newRouter 创建路由器，tunnel 为通过SSH隧道连接的函数
+func newRouter(cfg config.RoutingConfig, tunnel func(network, address string) (net.Conn, error)) (*router, error) {
+	r := &router{
+		cfg:       cfg,
+		tunnel:    tunnel,
+		upstreams: make(map[string]*url.URL),
+	}
+
+	for _, u := range cfg.Upstreams {
+		parsed, err := url.Parse(u.URL)
+		if err != nil {
+			return nil, fmt.Errorf("无效的上游代理 %s: %w", u.Name, err)
+		}
+		r.upstreams[u.Name] = parsed
+	}
+
+	inline, err := compileRules(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	r.inline = inline
+
+	if cfg.RulesFile != "" {
+		if err := r.loadFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// dial 按规则连接目标地址
+func (r *router) dial(network, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	rule := r.match(strings.ToLower(strings.TrimSuffix(host, ".")), port)
+	action, upstream := r.cfg.Default, ""
+	if rule != nil {
+		action, upstream = rule.action, rule.upstream
+	}
+
+	slog.Debug("路由选择", "target", address, "action", action, "upstream", upstream)
+
+	switch action {
+	case config.RouteDirect:
+		return net.DialTimeout(network, address, 30*time.Second)
+	case config.RouteReject:
+		return nil, fmt.Errorf("%w: %s (路由规则拒绝)", errNotAllowed, address)
+	case config.RouteUpstream:
+		return dialUpstream(r.upstreams[upstream], address)
+	default:
+		return r.tunnel(network, address)
+	}
+}
+
+// match 返回第一条匹配的规则，没有匹配时返回 nil
+func (r *router) match(host string, port int) *routeRule {
+	r.mu.Lock()
+	r.reloadIfChanged()
+	rules := make([]routeRule, 0, len(r.inline)+len(r.fileRules))
+	rules = append(rules, r.inline...)
+	rules = append(rules, r.fileRules...)
+	r.mu.Unlock()
+
+	addr, err := netip.ParseAddr(host)
+	isIP := err == nil
+
+	for i := range rules {
+		if rules[i].matches(host, addr, isIP, port) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// reloadIfChanged 规则文件修改后重新加载，调用时需持有锁
+func (r *router) reloadIfChanged() {
+	if r.cfg.RulesFile == "" || time.Since(r.lastCheck) < rulesCheckInterval {
+		return
+	}
+	r.lastCheck = time.Now()
+
+	info, err := os.Stat(r.cfg.RulesFile)
+	if err != nil || info.ModTime().Equal(r.fileMod) {
+		return
+	}
+
+	if err := r.loadFile(); err != nil {
+		slog.Warn("重新加载路由规则失败，继续使用旧规则", "file", r.cfg.RulesFile, "error", err)
+		return
+	}
+	slog.Info("路由规则已重新加载", "file", r.cfg.RulesFile, "rules", len(r.fileRules))
+}
+
+// loadFile 加载规则文件
+func (r *router) loadFile() error {
+	info, err := os.Stat(r.cfg.RulesFile)
+	if err != nil {
+		return fmt.Errorf("读取路由规则文件失败: %w", err)
+	}
+
+	rules, err := config.LoadRouteRules(r.cfg.RulesFile)
+	if err != nil {
+		return err
+	}
+	if err := r.cfg.Validate(rules); err != nil {
+		return fmt.Errorf("路由规则文件 %s: %w", r.cfg.RulesFile, err)
+	}
+
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+
+	r.fileRules = compiled
+	r.fileMod = info.ModTime()
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// compileRules 编译路由规则
+func compileRules(rules []config.RouteRule) ([]routeRule, error) {
+	compiled := make([]routeRule, 0, len(rules))
+	for _, rule := range rules {
+		c := routeRule{
+			action:   rule.Action,
+			upstream: rule.Upstream,
+		}
+		for _, d := range rule.Domains {
+			c.domains = append(c.domains, strings.ToLower(strings.Trim(d, ".")))
+		}
+		for _, g := range rule.Globs {
+			c.globs = append(c.globs, strings.ToLower(g))
+		}
+		for _, cidr := range rule.CIDRs {
+			prefix, err := config.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("无效的网段 %s: %w", cidr, err)
+			}
+			c.prefixes = append(c.prefixes, prefix.Masked())
+		}
+		for _, ports := range rule.Ports {
+			low, high, err := config.ParsePortRange(ports)
+			if err != nil {
+				return nil, err
+			}
+			c.ports = append(c.ports, [2]int{low, high})
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// matches 检查规则是否匹配目标
+func (c *routeRule) matches(host string, addr netip.Addr, isIP bool, port int) bool {
+	if len(c.domains) > 0 && (isIP || !matchAny(c.domains, func(d string) bool {
+		return host == d || strings.HasSuffix(host, "."+d)
+	})) {
+		return false
+	}
+
+	if len(c.globs) > 0 && !matchAny(c.globs, func(g string) bool {
+		ok, _ := path.Match(g, host)
+		return ok
+	}) {
+		return false
+	}
+
+	if len(c.prefixes) > 0 && (!isIP || !matchAny(c.prefixes, func(p netip.Prefix) bool {
+		return p.Contains(addr.Unmap())
+	})) {
+		return false
+	}
+
+	if len(c.ports) > 0 && !matchAny(c.ports, func(r [2]int) bool {
+		return port >= r[0] && port <= r[1]
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// matchAny 任意一项满足条件时返回 true
+func matchAny[T any](items []T, fn func(T) bool) bool {
+	for _, item := range items {
+		if fn(item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+)
+
+const (
+	// SOCKS4 版本
+	socks4Version = 0x04
+
+	// SOCKS4 响应状态
+	socks4Granted  = 0x5A
+	socks4Rejected = 0x5B
+)
+
+// serveSOCKS4 处理 SOCKS4/SOCKS4a 连接
+// SOCKS4 没有密码认证，配置了认证用户时拒绝所有 SOCKS4 请求
+func (s *socksServer) serveSOCKS4(ctx context.Context, conn *bufferedConn) {
+	targetAddr, err := s.readSOCKS4Request(conn)
+	if err != nil {
+		slog.Debug("读取SOCKS4请求失败", "error", err)
+		s.sendSOCKS4Reply(conn, socks4Rejected)
+		return
+	}
+
+	if s.creds != nil {
+		slog.Warn("SOCKS4不支持密码认证，已拒绝", "from", conn.RemoteAddr())
+		s.sendSOCKS4Reply(conn, socks4Rejected)
+		return
+	}
+
+	slog.Debug("SOCKS4连接请求", "from", conn.RemoteAddr(), "to", targetAddr)
+
+	// 连接目标
+	remoteConn, err := s.connect("tcp", targetAddr)
+	if err != nil {
+		slog.Debug("连接目标失败", "target", targetAddr, "error", err)
+		s.sendSOCKS4Reply(conn, socks4Rejected)
+		return
+	}
+	defer remoteConn.Close()
+
+	s.sendSOCKS4Reply(conn, socks4Granted)
+
+	// 双向转发数据
+	bidirectionalCopy(ctx, conn, remoteConn, s.limiter)
+}
+
+// readSOCKS4Request 读取 SOCKS4/4a 请求
+// VN | CD | DSTPORT(2) | DSTIP(4) | USERID | NULL [| DOMAIN | NULL]
+func (s *socksServer) readSOCKS4Request(conn *bufferedConn) (string, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("读取请求头失败: %w", err)
+	}
+
+	// 只支持 CONNECT 命令
+	if header[1] != cmdConnect {
+		return "", fmt.Errorf("不支持的命令: %d", header[1])
+	}
+
+	port := binary.BigEndian.Uint16(header[2:4])
+	ip := net.IP(header[4:8])
+
+	// 跳过 USERID
+	if _, err := conn.r.ReadSlice(0); err != nil {
+		return "", fmt.Errorf("读取USERID失败: %w", err)
+	}
+
+	host := ip.String()
+
+	// SOCKS4a: DSTIP 为 0.0.0.x (x != 0) 时，后面跟随域名
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := conn.r.ReadSlice(0)
+		if err != nil {
+			return "", fmt.Errorf("读取域名失败: %w", err)
+		}
+		host = string(domain[:len(domain)-1])
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// sendSOCKS4Reply 发送 SOCKS4 响应
+func (s *socksServer) sendSOCKS4Reply(conn net.Conn, rep byte) {
+	// VN(0) | CD | DSTPORT(2) | DSTIP(4)
+	conn.Write([]byte{0x00, rep, 0, 0, 0, 0, 0, 0})
+}
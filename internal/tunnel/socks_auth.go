@@ -0,0 +1,133 @@
+package tunnel
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"autossh/internal/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// RFC 1929 用户名/密码认证子协商版本
+	userPassVersion = 0x01
+
+	// 认证结果
+	userPassSuccess = 0x00
+	userPassFailure = 0x01
+)
+
+// socksCredentials SOCKS5 用户凭据 (用户名 -> 明文密码或 bcrypt 哈希)
+type socksCredentials map[string]string
+
+// loadSocksCredentials 从配置和用户文件加载凭据
+// 未配置认证时返回 nil
+func loadSocksCredentials(cfg config.SocksAuthConfig) (socksCredentials, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	creds := make(socksCredentials)
+	for _, u := range cfg.Users {
+		creds[u.Username] = u.Password
+	}
+
+	if cfg.UsersFile != "" {
+		if err := creds.loadFile(cfg.UsersFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("SOCKS5认证未配置任何用户")
+	}
+	return creds, nil
+}
+
+// loadFile 读取 htpasswd 格式的用户文件
+// 每行 user:password，password 为明文或 bcrypt 哈希，# 开头为注释
+func (c socksCredentials) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("读取SOCKS5用户文件失败: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, pass, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("SOCKS5用户文件 %s 第%d行格式无效", path, lineNo)
+		}
+		if strings.HasPrefix(pass, "$") && !isBcryptHash(pass) {
+			return fmt.Errorf("SOCKS5用户文件 %s 第%d行: 不支持的哈希格式 (仅支持 bcrypt)", path, lineNo)
+		}
+		c[user] = pass
+	}
+
+	return scanner.Err()
+}
+
+// verify 校验用户名和密码
+func (c socksCredentials) verify(user, pass string) bool {
+	stored, ok := c[user]
+	if !ok {
+		return false
+	}
+	if isBcryptHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(pass)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(pass)) == 1
+}
+
+// isBcryptHash 判断是否为 bcrypt 哈希
+func isBcryptHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// authenticate 执行 RFC 1929 用户名/密码认证子协商
+func (s *socksServer) authenticate(conn net.Conn) error {
+	// VER | ULEN | UNAME | PLEN | PASSWD
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("读取认证请求失败: %w", err)
+	}
+	if header[0] != userPassVersion {
+		return fmt.Errorf("不支持的认证子协商版本: %d", header[0])
+	}
+
+	user := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return fmt.Errorf("读取用户名失败: %w", err)
+	}
+
+	lenBuf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, lenBuf); err != nil {
+		return fmt.Errorf("读取密码长度失败: %w", err)
+	}
+	pass := make([]byte, lenBuf[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return fmt.Errorf("读取密码失败: %w", err)
+	}
+
+	if !s.creds.verify(string(user), string(pass)) {
+		conn.Write([]byte{userPassVersion, userPassFailure})
+		return fmt.Errorf("用户 %q 认证失败", user)
+	}
+
+	_, err := conn.Write([]byte{userPassVersion, userPassSuccess})
+	return err
+}
@@ -0,0 +1,236 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+	"autossh/internal/udprelay"
+)
+
+const (
+	// 远程 UDP 中继辅助进程的默认命令
+	defaultUDPHelperCommand = "autossh udp-relay"
+
+	// UDP 关联的默认空闲超时
+	defaultUDPIdleTimeout = 60 * time.Second
+)
+
+// udpAssociator 处理 SOCKS5 UDP ASSOCIATE
+// 每个关联在本地打开一个 UDP 端口，并在远程启动一个中继辅助进程，
+// 数据报经 SSH exec 通道的 stdin/stdout 以 udprelay 帧格式传输
+type udpAssociator struct {
+	client        *ssh.Client
+	helperCommand string
+	idleTimeout   time.Duration
+}
+
+// newUDPAssociator 创建 UDP 关联处理器
+func newUDPAssociator(client *ssh.Client, cfg config.SocksUDPConfig) *udpAssociator {
+	u := &udpAssociator{
+		client:        client,
+		helperCommand: cfg.HelperCommand,
+		idleTimeout:   cfg.IdleTimeout,
+	}
+	if u.helperCommand == "" {
+		u.helperCommand = defaultUDPHelperCommand
+	}
+	if u.idleTimeout <= 0 {
+		u.idleTimeout = defaultUDPIdleTimeout
+	}
+	return u
+}
+
+// associate 建立 UDP 关联，直到控制连接关闭、空闲超时或上下文取消
+func (u *udpAssociator) associate(ctx context.Context, s *socksServer, ctrl net.Conn) {
+	// 只接受来自控制连接同一 IP 的数据报
+	ctrlAddr, ok := ctrl.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		s.sendReply(ctrl, repServerFailure, nil)
+		return
+	}
+	clientIP := ctrlAddr.AddrPort().Addr().Unmap()
+
+	// 在控制连接的本地地址上打开 UDP 端口
+	localIP := net.IPv4zero
+	if addr, ok := ctrl.LocalAddr().(*net.TCPAddr); ok {
+		localIP = addr.IP
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		slog.Warn("UDP监听失败", "error", err)
+		s.sendReply(ctrl, repServerFailure, nil)
+		return
+	}
+	defer udpConn.Close()
+
+	// 启动远程中继辅助进程
+	session, err := u.client.NewSession()
+	if err != nil {
+		slog.Warn("创建UDP中继会话失败", "error", err)
+		s.sendReply(ctrl, repServerFailure, nil)
+		return
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		s.sendReply(ctrl, repServerFailure, nil)
+		return
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		s.sendReply(ctrl, repServerFailure, nil)
+		return
+	}
+	if err := session.Start(u.helperCommand); err != nil {
+		slog.Warn("启动远程UDP中继失败", "command", u.helperCommand, "error", err)
+		s.sendReply(ctrl, repServerFailure, nil)
+		return
+	}
+
+	bindAddr := udpConn.LocalAddr().(*net.UDPAddr)
+	s.sendReply(ctrl, repSuccess, &net.TCPAddr{IP: bindAddr.IP, Port: bindAddr.Port})
+	slog.Debug("UDP关联已建立", "client", ctrl.RemoteAddr(), "bind", bindAddr)
+
+	// 关联结束时中断限速和配额的等待
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	toTarget, toClient := s.limiter.buckets()
+	q := s.limiter.quota()
+
+	var (
+		lastActive atomic.Int64
+		clientAddr atomic.Pointer[netip.AddrPort]
+		done       = make(chan struct{})
+		doneOnce   sync.Once
+	)
+	finish := func() { doneOnce.Do(func() { close(done) }) }
+	lastActive.Store(time.Now().UnixNano())
+
+	// 本地 -> 远程
+	go func() {
+		defer finish()
+		buf := make([]byte, udprelay.MaxFrameSize)
+		for {
+			n, from, err := udpConn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if from.Addr().Unmap() != clientIP {
+				continue
+			}
+
+			// 客户端的源端口以第一个数据报为准
+			if p := clientAddr.Load(); p == nil {
+				clientAddr.Store(&from)
+			} else if *p != from {
+				continue
+			}
+
+			// RSV(2) | FRAG(1) | ATYP | DST.ADDR | DST.PORT | DATA，不支持分片
+			if n < 4 || buf[2] != 0 {
+				continue
+			}
+			dst, hdrLen, err := udprelay.ParseAddr(buf[3:n])
+			if err != nil {
+				continue
+			}
+			if !s.acl.permits(dst) {
+				slog.Debug("UDP目标被访问控制拒绝", "target", dst)
+				continue
+			}
+			if chargeDatagram(ctx, q, toTarget, n-3-hdrLen) != nil {
+				return
+			}
+
+			lastActive.Store(time.Now().UnixNano())
+			err = udprelay.WriteFrame(stdin, dst, buf[3+hdrLen:n])
+			if err != nil && !errors.Is(err, udprelay.ErrFrameTooLarge) {
+				return
+			}
+		}
+	}()
+
+	// 远程 -> 本地
+	go func() {
+		defer finish()
+		for {
+			src, payload, err := udprelay.ReadFrame(stdout)
+			if err != nil {
+				if err != io.EOF {
+					slog.Debug("读取远程UDP中继失败", "error", err)
+				}
+				return
+			}
+
+			to := clientAddr.Load()
+			if to == nil {
+				continue
+			}
+			if chargeDatagram(ctx, q, toClient, len(payload)) != nil {
+				return
+			}
+
+			packet, err := udprelay.AppendAddr([]byte{0, 0, 0}, src)
+			if err != nil {
+				continue
+			}
+			packet = append(packet, payload...)
+
+			lastActive.Store(time.Now().UnixNano())
+			udpConn.WriteToUDPAddrPort(packet, *to)
+		}
+	}()
+
+	// 控制连接关闭即结束关联
+	go func() {
+		defer finish()
+		io.Copy(io.Discard, ctrl)
+	}()
+
+	timer := time.NewTimer(u.idleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			slog.Debug("UDP关联已结束", "client", ctrl.RemoteAddr())
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, lastActive.Load()))
+			if idle >= u.idleTimeout {
+				slog.Debug("UDP关联空闲超时", "client", ctrl.RemoteAddr(), "timeout", u.idleTimeout)
+				return
+			}
+			timer.Reset(u.idleTimeout - idle)
+		}
+	}
+}
+
+// chargeDatagram 将 n 字节数据报计入流量配额并按速率等待，与 TCP 转发共用限速和配额
+// 暂停模式下配额用尽时等待配额恢复
+func chargeDatagram(ctx context.Context, q *quota, buckets []*tokenBucket, n int) error {
+	if q != nil {
+		if err := q.wait(ctx); err != nil {
+			return err
+		}
+		q.add(n)
+	}
+	for _, b := range buckets {
+		if err := b.wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hookTimeout 钩子命令的最长执行时间
+const hookTimeout = 30 * time.Second
+
+// remoteState 状态文件中记录的远程转发
+type remoteState struct {
+	Name   string `json:"name"`
+	Bind   string `json:"bind"`           // 配置的监听地址
+	Addr   string `json:"addr"`           // 实际监听地址
+	Port   int    `json:"port,omitempty"` // 实际监听端口 (Unix 套接字省略)
+	Target string `json:"target"`
+}
+
+// runtimeState 状态文件内容
+type runtimeState struct {
+	Server  string        `json:"server"`
+	PID     int           `json:"pid"`
+	Updated time.Time     `json:"updated"`
+	Remote  []remoteState `json:"remote"`
+}
+
+// remoteListening 记录远程转发的实际监听地址，更新状态文件并执行钩子
+func (m *Manager) remoteListening(tunnel *RemoteTunnel, spec remoteState, hook string) {
+	m.stateMu.Lock()
+	m.listening[tunnel] = spec
+	m.saveStateLocked()
+	m.stateMu.Unlock()
+
+	if hook != "" {
+		go runHook(hook, []string{
+			"AUTOSSH_EVENT=remote_listen",
+			"AUTOSSH_SERVER=" + m.cfg.Address(),
+			"AUTOSSH_TUNNEL_NAME=" + spec.Name,
+			"AUTOSSH_REMOTE_BIND=" + spec.Bind,
+			"AUTOSSH_REMOTE_ADDR=" + spec.Addr,
+			"AUTOSSH_REMOTE_PORT=" + strconv.Itoa(spec.Port),
+			"AUTOSSH_TARGET=" + spec.Target,
+		})
+	}
+}
+
+// remoteStopped 移除单独停止的远程转发 (取消转发) 并更新状态文件
+func (m *Manager) remoteStopped(tunnel *RemoteTunnel) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	m.remotes = slices.DeleteFunc(m.remotes, func(t *RemoteTunnel) bool { return t == tunnel })
+	if _, ok := m.listening[tunnel]; ok {
+		delete(m.listening, tunnel)
+		m.saveStateLocked()
+	}
+}
+
+// clearState 所有隧道停止 (连接断开、重启或退出) 后清空远程转发记录并更新状态文件，
+// 避免状态文件中残留已不再监听的端口
+func (m *Manager) clearState() {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	m.remotes = nil
+	if len(m.listening) > 0 {
+		clear(m.listening)
+		m.saveStateLocked()
+	}
+}
+
+// saveStateLocked 写入状态文件，调用时需持有 stateMu
+func (m *Manager) saveStateLocked() {
+	if m.cfg.StateFile == "" {
+		return
+	}
+	state := runtimeState{
+		Server:  m.cfg.Address(),
+		PID:     os.Getpid(),
+		Updated: time.Now(),
+		Remote:  []remoteState{},
+	}
+	for _, t := range m.remotes {
+		if r, ok := m.listening[t]; ok {
+			state.Remote = append(state.Remote, r)
+		}
+	}
+	if err := writeState(m.cfg.StateFile, state); err != nil {
+		slog.Warn("写入状态文件失败", "file", m.cfg.StateFile, "error", err)
+	}
+}
+
+// writeState 写入状态文件 (先写临时文件再重命名)
+func writeState(path string, state runtimeState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runHook 通过 sh -c 执行钩子命令，env 追加到当前环境变量
+func runHook(command string, env []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		slog.Warn("钩子命令执行失败", "command", command, "error", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	slog.Debug("钩子命令已执行", "command", command)
+}
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"syscall"
+
+	"autossh/internal/config"
+	"autossh/internal/ssh"
+)
+
+// StdioForward 标准输入输出转发 (-W)
+// 将 stdin/stdout 通过 SSH 连接到远程目标，可作为其他工具的 ProxyCommand
+type StdioForward struct {
+	client *ssh.Client
+	target string
+	in     io.Reader
+	out    io.Writer
+}
+
+// NewStdioForward 创建标准输入输出转发
+func NewStdioForward(client *ssh.Client, target string) *StdioForward {
+	return &StdioForward{
+		client: client,
+		target: target,
+		in:     os.Stdin,
+		out:    os.Stdout,
+	}
+}
+
+// Run 连接目标并转发数据，目标关闭连接、stdout 关闭或 ctx 取消时返回
+// stdin 结束时向目标发送 EOF (半关闭)，继续等待目标的响应
+func (s *StdioForward) Run(ctx context.Context) error {
+	conn, err := s.client.Dial(config.Network(s.target), s.target)
+	if err != nil {
+		return fmt.Errorf("连接目标失败 %s: %w", s.target, err)
+	}
+	defer conn.Close()
+	slog.Debug("标准输入输出转发已建立", "target", s.target)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// stdin -> 目标
+	go func() {
+		_, err := copyConn(conn, s.in)
+		if cw, ok := conn.(closeWriter); ok && err == nil {
+			cw.CloseWrite()
+			return
+		}
+		// 不支持半关闭或读取 stdin 出错时直接结束
+		cancel()
+	}()
+
+	// 目标 -> stdout
+	_, err = copyConn(s.out, conn)
+	switch {
+	case ctx.Err() != nil:
+		return nil
+	case errors.Is(err, syscall.EPIPE):
+		// stdout 已关闭 (调用方退出)
+		return nil
+	case err != nil:
+		return fmt.Errorf("标准输入输出转发失败: %w", err)
+	}
+	slog.Debug("目标已关闭连接", "target", s.target)
+	return nil
+}
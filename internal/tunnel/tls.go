@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"autossh/internal/config"
+)
+
+const (
+	// tlsHandshakeTimeout TLS 握手超时
+	tlsHandshakeTimeout = 10 * time.Second
+
+	// certCheckInterval 检查证书文件是否修改的最小间隔
+	certCheckInterval = time.Second
+)
+
+// tlsTerminator 本地监听的 TLS 终止，证书文件修改后自动重新加载
+type tlsTerminator struct {
+	cfg config.ListenerTLSConfig
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool // 为 nil 时不校验客户端证书
+	modTimes  [3]time.Time   // cert, key, client_ca
+	lastCheck time.Time
+}
+
+// newTLSTerminator 创建 TLS 终止并加载证书，未启用 TLS 时返回 nil
+func newTLSTerminator(cfg config.ListenerTLSConfig) (*tlsTerminator, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	t := &tlsTerminator{cfg: cfg}
+	modTimes, err := t.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.load(modTimes); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// server 在连接上完成 TLS 握手，t 为 nil 时原样返回
+func (t *tlsTerminator) server(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if t == nil {
+		return conn, nil
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{GetConfigForClient: t.configForClient})
+
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS握手失败: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// configForClient 为每个连接返回当前证书的 TLS 配置
+func (t *tlsTerminator) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mu.Lock()
+	t.reloadIfChanged()
+	cert, clientCAs := t.cert, t.clientCAs
+	t.mu.Unlock()
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAs != nil {
+		c.ClientCAs = clientCAs
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// reloadIfChanged 证书文件修改后重新加载，失败时继续使用旧证书，调用时需持有锁
+func (t *tlsTerminator) reloadIfChanged() {
+	if time.Since(t.lastCheck) < certCheckInterval {
+		return
+	}
+	t.lastCheck = time.Now()
+
+	modTimes, err := t.stat()
+	if err != nil || modTimes == t.modTimes {
+		return
+	}
+
+	if err := t.load(modTimes); err != nil {
+		slog.Warn("重新加载TLS证书失败，继续使用旧证书", "cert", t.cfg.Cert, "error", err)
+		return
+	}
+	slog.Info("TLS证书已重新加载", "cert", t.cfg.Cert)
+}
+
+// stat 返回证书文件的修改时间
+func (t *tlsTerminator) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{t.cfg.Cert, t.cfg.Key, t.cfg.ClientCA} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, fmt.Errorf("读取TLS文件失败: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load 加载证书、私钥和客户端 CA
+func (t *tlsTerminator) load(modTimes [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(t.cfg.Cert, t.cfg.Key)
+	if err != nil {
+		return fmt.Errorf("加载TLS证书失败: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if t.cfg.ClientCA != "" {
+		pem, err := os.ReadFile(t.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("读取客户端CA失败: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("客户端CA文件中没有有效的证书: %s", t.cfg.ClientCA)
+		}
+	}
+
+	t.cert = &cert
+	t.clientCAs = clientCAs
+	t.modTimes = modTimes
+	t.lastCheck = time.Now()
+	return nil
+}
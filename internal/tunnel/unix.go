@@ -0,0 +1,170 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"autossh/internal/config"
+)
+
+// listen 在本地监听 TCP 地址或 Unix 套接字
+// 对于 Unix 套接字，会先清理残留文件，并按配置设置权限和属主
+// systemd 传递了相同地址的监听套接字时直接使用
+func listen(addr, mode, owner string) (net.Listener, error) {
+	if listener, ok, err := inheritedListener(addr); ok {
+		return listener, err
+	}
+
+	if !config.IsUnixSocket(addr) {
+		return net.Listen("tcp", addr)
+	}
+
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+
+	var fileMode os.FileMode
+	if mode != "" {
+		var err error
+		if fileMode, err = config.ParseFileMode(mode); err != nil {
+			return nil, fmt.Errorf("设置套接字权限失败: %w", err)
+		}
+	}
+	return ListenUnix(addr, fileMode, owner)
+}
+
+// ListenUnix 在 path 监听 Unix 套接字，mode 为 0 时不修改权限
+// 套接字先在同一目录下权限为 0700 的临时目录中创建，设置权限和属主后再重命名到 path，
+// 避免其他用户在设置权限之前连接
+func ListenUnix(path string, mode os.FileMode, owner string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".autossh-")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// 重命名后临时路径不再存在，关闭时由 unixListener 删除 path
+	listener.SetUnlinkOnClose(false)
+
+	if mode != 0 {
+		if err := os.Chmod(tmp, mode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("设置套接字权限失败: %w", err)
+		}
+	}
+	if owner != "" {
+		if err := chownSocket(tmp, owner); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("设置套接字属主失败: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// unixListener 重命名后的 Unix 套接字监听，Addr 返回最终路径，关闭时删除套接字文件
+type unixListener struct {
+	*net.UnixListener
+	path  string
+	close sync.Once
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	// 只删除一次，避免删除之后重新监听创建的同名套接字
+	l.close.Do(func() { os.Remove(l.path) })
+	return err
+}
+
+// removeStaleSocket 删除无人监听的残留套接字文件
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是套接字文件", path)
+	}
+
+	// 仍有进程在监听则不能删除
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("套接字 %s 正在被使用", path)
+	}
+
+	slog.Debug("删除残留的套接字文件", "path", path)
+	return os.Remove(path)
+}
+
+// chownSocket 修改套接字文件属主，owner 格式为 user[:group]
+func chownSocket(path, owner string) error {
+	userName, groupName, _ := strings.Cut(owner, ":")
+
+	uid, gid := -1, -1
+	if userName != "" {
+		id, err := lookupID(userName, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		uid = id
+	}
+	if groupName != "" {
+		id, err := lookupID(groupName, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		gid = id
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID 将用户名/组名或数字ID解析为数字ID
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	idStr, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(idStr)
+}
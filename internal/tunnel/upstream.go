@@ -0,0 +1,154 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"autossh/internal/udprelay"
+)
+
+// dialUpstream 通过上游代理 (SOCKS5 或 HTTP CONNECT) 连接目标地址
+func dialUpstream(upstream *url.URL, address string) (net.Conn, error) {
+	if upstream == nil {
+		return nil, fmt.Errorf("未定义的上游代理")
+	}
+
+	conn, err := net.DialTimeout("tcp", upstream.Host, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("连接上游代理失败 %s: %w", upstream.Host, err)
+	}
+
+	// 握手阶段设置超时
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	switch upstream.Scheme {
+	case "socks5":
+		err = socks5Connect(conn, upstream.User, address)
+	case "http":
+		conn, err = httpConnect(conn, upstream.User, address)
+	default:
+		err = fmt.Errorf("不支持的上游代理类型: %s", upstream.Scheme)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5Connect 作为客户端与 SOCKS5 上游代理握手并发送 CONNECT 请求
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) error {
+	method := byte(authNone)
+	if user != nil {
+		method = authPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取上游握手响应失败: %w", err)
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return fmt.Errorf("上游代理不接受认证方法: %d", method)
+	}
+
+	if method == authPassword {
+		// RFC 1929: 用户名和密码长度各占一个字节
+		pass, _ := user.Password()
+		if len(user.Username()) == 0 || len(user.Username()) > 255 || len(pass) > 255 {
+			return fmt.Errorf("上游代理的用户名不能为空，用户名和密码长度不能超过255")
+		}
+		req := []byte{userPassVersion, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(pass)))
+		req = append(req, pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("读取上游认证响应失败: %w", err)
+		}
+		if reply[0] != userPassVersion {
+			return fmt.Errorf("上游认证响应版本无效: %d", reply[0])
+		}
+		if reply[1] != userPassSuccess {
+			return fmt.Errorf("上游代理认证失败")
+		}
+	}
+
+	// VER | CMD | RSV | ATYP | DST.ADDR | DST.PORT
+	req, err := udprelay.AppendAddr([]byte{socks5Version, cmdConnect, 0x00}, address)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER | REP | RSV | ATYP | BND.ADDR | BND.PORT
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("读取上游响应失败: %w", err)
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("上游响应版本无效: %d", header[0])
+	}
+	if header[1] != repSuccess {
+		return fmt.Errorf("上游代理连接失败: %d", header[1])
+	}
+
+	var addrLen int
+	switch header[3] {
+	case addrTypeIPv4:
+		addrLen = 4
+	case addrTypeIPv6:
+		addrLen = 16
+	case addrTypeDomain:
+		lenBuf := make([]byte, 1)
+		if _, err := io.ReadFull(conn, lenBuf); err != nil {
+			return err
+		}
+		addrLen = int(lenBuf[0])
+	default:
+		return fmt.Errorf("上游响应地址类型无效: %d", header[3])
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// httpConnect 通过 HTTP CONNECT 上游代理建立隧道
+func httpConnect(conn net.Conn, user *url.Userinfo, address string) (net.Conn, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if user != nil {
+		pass, _ := user.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		req += "Proxy-Authorization: Basic " + cred + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return conn, err
+	}
+
+	bc := newBufferedConn(conn)
+	resp, err := http.ReadResponse(bc.r, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return conn, fmt.Errorf("读取上游响应失败: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("上游代理连接失败: %s", resp.Status)
+	}
+	return bc, nil
+}
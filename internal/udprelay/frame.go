@@ -0,0 +1,139 @@
+// Package udprelay 实现通过 SSH exec 通道中继 UDP 数据报
+//
+// 本地 SOCKS5 UDP ASSOCIATE 与远程辅助进程 (autossh udp-relay) 之间
+// 通过会话的 stdin/stdout 交换帧，帧格式:
+//
+//	LEN(2) | ATYP(1) | ADDR | PORT(2) | DATA
+//
+// LEN 为其后所有字节的长度 (大端序)，地址编码与 SOCKS5 相同。
+// 本地发往远程的帧中地址为目标地址，远程发回的帧中地址为来源地址。
+package udprelay
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// 地址类型 (与 SOCKS5 相同)
+const (
+	addrTypeIPv4   = 0x01
+	addrTypeDomain = 0x03
+	addrTypeIPv6   = 0x04
+)
+
+// MaxFrameSize 帧的最大长度 (不含长度前缀)
+const MaxFrameSize = 0xFFFF
+
+// ErrFrameTooLarge 帧超过最大长度
+var ErrFrameTooLarge = errors.New("UDP帧过大")
+
+// WriteFrame 写入一个帧
+func WriteFrame(w io.Writer, addr string, payload []byte) error {
+	buf := make([]byte, 2, 2+1+256+2+len(payload))
+	buf, err := AppendAddr(buf, addr)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, payload...)
+
+	if len(buf)-2 > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	binary.BigEndian.PutUint16(buf, uint16(len(buf)-2))
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadFrame 读取一个帧，返回地址和数据
+func ReadFrame(r io.Reader) (addr string, payload []byte, err error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return "", nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", nil, fmt.Errorf("读取UDP帧失败: %w", err)
+	}
+
+	addr, n, err := ParseAddr(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	return addr, buf[n:], nil
+}
+
+// AppendAddr 将 host:port 按 SOCKS5 地址格式追加到 b
+func AppendAddr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口号: %s", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, addrTypeIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, addrTypeIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("域名过长: %s", host)
+		}
+		b = append(b, addrTypeDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// ParseAddr 解析 SOCKS5 地址格式，返回 host:port 和消耗的字节数
+func ParseAddr(b []byte) (addr string, n int, err error) {
+	if len(b) < 1 {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+
+	var host string
+	switch b[0] {
+	case addrTypeIPv4:
+		n = 1 + 4
+		if len(b) < n+2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		host = net.IP(b[1:n]).String()
+
+	case addrTypeDomain:
+		if len(b) < 2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		n = 2 + int(b[1])
+		if len(b) < n+2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		host = string(b[2:n])
+
+	case addrTypeIPv6:
+		n = 1 + 16
+		if len(b) < n+2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		host = net.IP(b[1:n]).String()
+
+	default:
+		return "", 0, fmt.Errorf("不支持的地址类型: %d", b[0])
+	}
+
+	port := binary.BigEndian.Uint16(b[n:])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), n + 2, nil
+}
@@ -0,0 +1,68 @@
+package udprelay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// maxResolveCache 域名解析缓存的最大条目数
+const maxResolveCache = 1024
+
+// Serve 运行远程辅助进程的中继循环
+// 从 r 读取帧并发送到目标地址，将收到的数据报连同来源地址写回 w，r 结束时返回
+func Serve(r io.Reader, w io.Writer) error {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// 接收数据报并写回
+	var wmu sync.Mutex
+	go func() {
+		buf := make([]byte, MaxFrameSize)
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			src := netip.AddrPortFrom(from.Addr().Unmap(), from.Port()).String()
+
+			wmu.Lock()
+			err = WriteFrame(w, src, buf[:n])
+			wmu.Unlock()
+			if err != nil && !errors.Is(err, ErrFrameTooLarge) {
+				return
+			}
+		}
+	}()
+
+	// 读取帧并发送
+	resolved := make(map[string]*net.UDPAddr)
+	for {
+		addr, payload, err := ReadFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		udpAddr, ok := resolved[addr]
+		if !ok {
+			udpAddr, err = net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				continue
+			}
+			if len(resolved) >= maxResolveCache {
+				clear(resolved)
+			}
+			resolved[addr] = udpAddr
+		}
+
+		conn.WriteToUDP(payload, udpAddr)
+	}
+}